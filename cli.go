@@ -43,7 +43,10 @@ type Command struct {
 	k8sVersion         string
 	persistentFlags    []*pflag.FlagSet
 	persistentPreRunCb []func() error
+	configCb           []func(context.Context, *opts.Opts) error
 	opts               *opts.Opts
+	nodes              []opts.NodeSpec
+	conditionChecks    []provider.NodeConditionCheck
 }
 
 // ContextWithCancelOnSignal returns a context which will be cancelled when
@@ -123,6 +126,36 @@ func WithPersistentPreRunCallback(f func() error) Option {
 	}
 }
 
+// WithConfigCallback adds a callback which is called with the resolved options
+// before the node is started, and again every time the options are reloaded
+// (on SIGHUP or when the config file changes).
+//
+// This is the place to apply options which are not handled by the root
+// command itself.
+func WithConfigCallback(f func(context.Context, *opts.Opts) error) Option {
+	return func(c *Command) {
+		c.configCb = append(c.configCb, f)
+	}
+}
+
+// WithNodes sets the nodes to run in the process, see `opts.Opts.Nodes`.
+// Nodes set in the config file replace these.
+func WithNodes(specs ...opts.NodeSpec) Option {
+	return func(c *Command) {
+		c.nodes = append(c.nodes, specs...)
+	}
+}
+
+// WithNodeConditionCheck registers a check which periodically sets one
+// condition of the status of every node, see `provider.NodeConditionCheck`.
+// The expiry of the serving certificate of every node is checked without
+// registering anything, see `provider.ServingCertificateExpiring`.
+func WithNodeConditionCheck(check provider.NodeConditionCheck) Option {
+	return func(c *Command) {
+		c.conditionChecks = append(c.conditionChecks, check)
+	}
+}
+
 // New creates a new command.
 // Call `Run()` on the returned object to run the command.
 func New(ctx context.Context, options ...Option) (*Command, error) {
@@ -144,8 +177,14 @@ func New(ctx context.Context, options ...Option) (*Command, error) {
 	if c.k8sVersion != "" {
 		flagOpts.Version = c.k8sVersion
 	}
+	if len(c.nodes) > 0 {
+		flagOpts.Nodes = c.nodes
+	}
 
-	c.cmd = root.NewCommand(name, c.s, flagOpts)
+	c.cmd = root.NewCommand(name, c.s, flagOpts, root.Extensions{
+		ConfigCallbacks:     c.configCb,
+		NodeConditionChecks: c.conditionChecks,
+	})
 	for _, f := range c.persistentFlags {
 		c.cmd.PersistentFlags().AddFlagSet(f)
 	}
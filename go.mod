@@ -10,10 +10,13 @@ require (
 	github.com/spf13/pflag v1.0.5
 	github.com/virtual-kubelet/virtual-kubelet v1.6.0
 	go.opencensus.io v0.22.2
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
 	gotest.tools v2.2.0+incompatible
 	k8s.io/api v0.19.10
 	k8s.io/apimachinery v0.19.10
 	k8s.io/apiserver v0.19.10
 	k8s.io/client-go v0.19.10
 	k8s.io/klog v1.0.0
+	k8s.io/kubelet v0.19.10
+	sigs.k8s.io/yaml v1.2.0
 )
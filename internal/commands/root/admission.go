@@ -0,0 +1,264 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// admittingProvider rejects the pods which request more of the extended
+// resources than the node has free before they are created in the wrapped
+// provider.
+//
+// Like the kubelet, a rejected pod is failed with an `OutOf<resource>`
+// reason. The pods running in the provider are what is in use, so pods
+// requesting extended resources are created one at a time.
+type admittingProvider struct {
+	provider.Provider
+	recorder    record.EventRecorder
+	allocatable corev1.ResourceList
+
+	mu sync.Mutex
+
+	rejectedMu sync.Mutex
+	rejected   map[types.UID]corev1.PodStatus
+}
+
+// The wrappers below keep the optional interfaces of the wrapped provider
+// visible to the pod controller and the HTTP server.
+
+type admittingPodNotifier struct {
+	*admittingProvider
+	node.PodNotifier
+}
+
+type admittingMetricsProvider struct {
+	*admittingProvider
+	provider.PodMetricsProvider
+}
+
+type admittingPodNotifierMetricsProvider struct {
+	*admittingProvider
+	node.PodNotifier
+	provider.PodMetricsProvider
+}
+
+// newAdmittingProvider wraps p to admit pods against the allocatable
+// quantities of the extended resources of the node n. p and pods are
+// returned as is when the node has no extended resources.
+//
+// The pod controller must use the returned pods client: the controller
+// updates the status of a pod it failed to create, and the client turns that
+// update into the rejection of the pod.
+func newAdmittingProvider(p provider.Provider, pods corev1client.PodsGetter, recorder record.EventRecorder, n *corev1.Node, extended corev1.ResourceList) (provider.Provider, corev1client.PodsGetter) {
+	if len(extended) == 0 {
+		return p, pods
+	}
+	allocatable := make(corev1.ResourceList, len(extended))
+	for name := range extended {
+		allocatable[name] = n.Status.Allocatable[name]
+	}
+	ap := &admittingProvider{
+		Provider:    p,
+		recorder:    recorder,
+		allocatable: allocatable,
+		rejected:    make(map[types.UID]corev1.PodStatus),
+	}
+	pods = admittingPodsGetter{PodsGetter: pods, p: ap}
+
+	notifier, isNotifier := p.(node.PodNotifier)
+	metrics, isMetrics := p.(provider.PodMetricsProvider)
+	switch {
+	case isNotifier && isMetrics:
+		return admittingPodNotifierMetricsProvider{ap, notifier, metrics}, pods
+	case isNotifier:
+		return admittingPodNotifier{ap, notifier}, pods
+	case isMetrics:
+		return admittingMetricsProvider{ap, metrics}, pods
+	}
+	return ap, pods
+}
+
+// CreatePod implements node.PodLifecycleHandler.
+func (p *admittingProvider) CreatePod(ctx context.Context, pod *corev1.Pod) error {
+	requests := podRequests(pod, p.allocatable)
+	if len(requests) == 0 {
+		return p.Provider.CreatePod(ctx, pod)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	running, err := p.Provider.GetPods(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error listing the pods to admit the pod")
+	}
+	used := make(corev1.ResourceList, len(p.allocatable))
+	for _, other := range running {
+		if other.UID == pod.UID || isTerminal(other) {
+			continue
+		}
+		addResources(used, podRequests(other, p.allocatable))
+	}
+
+	if reason, message := admitResources(requests, used, p.allocatable); reason != "" {
+		return p.reject(ctx, pod, reason, message)
+	}
+	return p.Provider.CreatePod(ctx, pod)
+}
+
+// reject fails pod with reason and message instead of creating it, like the
+// kubelet does when a pod fails admission. The returned error makes the pod
+// controller update the status of the pod, which the pods client of the
+// provider replaces with the rejection.
+func (p *admittingProvider) reject(ctx context.Context, pod *corev1.Pod, reason, message string) error {
+	log.G(ctx).WithField("reason", reason).Warn("Rejecting pod: " + message)
+	p.recorder.Event(pod, corev1.EventTypeWarning, reason, message)
+
+	p.rejectedMu.Lock()
+	p.rejected[pod.UID] = corev1.PodStatus{Phase: corev1.PodFailed, Reason: reason, Message: message}
+	p.rejectedMu.Unlock()
+	return errdefs.InvalidInputf("pod rejected: %s", message)
+}
+
+// rejection returns the status to fail the pod uid with, if it was rejected
+// since the last update of its status.
+func (p *admittingProvider) rejection(uid types.UID) (corev1.PodStatus, bool) {
+	p.rejectedMu.Lock()
+	defer p.rejectedMu.Unlock()
+	status, ok := p.rejected[uid]
+	delete(p.rejected, uid)
+	return status, ok
+}
+
+// admittingPodsGetter fails the pods rejected by an admittingProvider when
+// their status is updated.
+type admittingPodsGetter struct {
+	corev1client.PodsGetter
+	p *admittingProvider
+}
+
+// Pods implements corev1client.PodsGetter.
+func (g admittingPodsGetter) Pods(namespace string) corev1client.PodInterface {
+	return admittingPods{PodInterface: g.PodsGetter.Pods(namespace), p: g.p}
+}
+
+type admittingPods struct {
+	corev1client.PodInterface
+	p *admittingProvider
+}
+
+// UpdateStatus implements corev1client.PodInterface.
+func (c admittingPods) UpdateStatus(ctx context.Context, pod *corev1.Pod, opts metav1.UpdateOptions) (*corev1.Pod, error) {
+	if status, ok := c.p.rejection(pod.UID); ok {
+		pod = pod.DeepCopy()
+		pod.Status.Phase = status.Phase
+		pod.Status.Reason = status.Reason
+		pod.Status.Message = status.Message
+	}
+	return c.PodInterface.UpdateStatus(ctx, pod, opts)
+}
+
+// admitResources checks that requests fit in what is left of allocatable
+// after used. If not, it returns the reason and message to reject the pod
+// with.
+func admitResources(requests, used, allocatable corev1.ResourceList) (string, string) {
+	names := make([]string, 0, len(requests))
+	for name := range requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		n := corev1.ResourceName(name)
+		requested, inUse, capacity := requests[n], used[n], allocatable[n]
+		free := capacity.DeepCopy()
+		free.Sub(inUse)
+		if requested.Cmp(free) > 0 {
+			return "OutOf" + name, fmt.Sprintf("Node didn't have enough resource: %s, requested: %s, used: %s, capacity: %s",
+				name, requested.String(), inUse.String(), capacity.String())
+		}
+	}
+	return "", ""
+}
+
+// podRequests returns the quantities of the resources in names requested by
+// pod: the largest of the sum of the containers and of any init container,
+// plus the pod overhead. A container without requests for a resource
+// requests its limit.
+func podRequests(pod *corev1.Pod, names corev1.ResourceList) corev1.ResourceList {
+	requests := make(corev1.ResourceList)
+	for _, c := range pod.Spec.Containers {
+		addResources(requests, containerRequests(c, names))
+	}
+	for _, c := range pod.Spec.InitContainers {
+		for name, q := range containerRequests(c, names) {
+			if r, ok := requests[name]; !ok || q.Cmp(r) > 0 {
+				requests[name] = q
+			}
+		}
+	}
+	for name, q := range pod.Spec.Overhead {
+		if r, ok := requests[name]; ok {
+			r.Add(q)
+			requests[name] = r
+		}
+	}
+	for name, q := range requests {
+		if q.IsZero() {
+			delete(requests, name)
+		}
+	}
+	return requests
+}
+
+func containerRequests(c corev1.Container, names corev1.ResourceList) corev1.ResourceList {
+	requests := make(corev1.ResourceList)
+	for name := range names {
+		if q, ok := c.Resources.Requests[name]; ok {
+			requests[name] = q.DeepCopy()
+		} else if q, ok := c.Resources.Limits[name]; ok {
+			requests[name] = q.DeepCopy()
+		}
+	}
+	return requests
+}
+
+// addResources adds the quantities in add to l.
+func addResources(l, add corev1.ResourceList) {
+	for name, q := range add {
+		r := l[name]
+		r.Add(q)
+		l[name] = r
+	}
+}
+
+func isTerminal(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
@@ -0,0 +1,118 @@
+package root
+
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/node-cli/provider/mock"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newLicensePod(name string, licenses string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name)},
+		Spec: corev1.PodSpec{
+			NodeName: "node",
+			Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{"example.com/license": resource.MustParse(licenses)},
+				},
+			}},
+		},
+	}
+}
+
+func TestAdmittingProvider(t *testing.T) {
+	ctx := context.Background()
+
+	mp, err := mock.NewProviderConfig(mock.Config{CPU: "1", Memory: "1G", Pods: "10"}, "node", "Linux", "127.0.0.1", 10250)
+	assert.NilError(t, err)
+	extended := corev1.ResourceList{"example.com/license": resource.MustParse("3")}
+	n := &corev1.Node{Status: corev1.NodeStatus{Allocatable: extended}}
+
+	pods := []*corev1.Pod{newLicensePod("a", "2"), newLicensePod("b", "2"), newLicensePod("c", "1")}
+	client := fake.NewSimpleClientset(pods[0], pods[1], pods[2])
+	recorder := record.NewFakeRecorder(10)
+
+	p, podClient := newAdmittingProvider(mp, client.CoreV1(), recorder, n, extended)
+	_, ok := p.(node.PodNotifier)
+	assert.Check(t, ok, "the pod notifier of the provider is hidden")
+	_, ok = p.(provider.PodMetricsProvider)
+	assert.Check(t, ok, "the pod metrics of the provider are hidden")
+
+	assert.NilError(t, p.CreatePod(ctx, pods[0]))
+	rejectErr := p.CreatePod(ctx, pods[1])
+	assert.Check(t, errdefs.IsInvalidInput(rejectErr), "b is admitted: %v", rejectErr)
+	assert.NilError(t, p.CreatePod(ctx, pods[2]))
+
+	// b does not fit next to a, c does
+	running, err := mp.GetPods(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(running, 2))
+	assert.Check(t, is.Len(recorder.Events, 1))
+
+	// The pod controller fails the pod it could not create, the rejection
+	// replaces its status
+	b := pods[1].DeepCopy()
+	b.Status.Phase = corev1.PodPending
+	b.Status.Reason = "ProviderFailed"
+	b.Status.Message = rejectErr.Error()
+	_, err = podClient.Pods("default").UpdateStatus(ctx, b, metav1.UpdateOptions{})
+	assert.NilError(t, err)
+	b, err = client.CoreV1().Pods("default").Get(ctx, "b", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(b.Status.Phase, corev1.PodFailed))
+	assert.Check(t, is.Equal(b.Status.Reason, "OutOfexample.com/license"))
+	assert.Check(t, is.Equal(b.Status.Message, "Node didn't have enough resource: example.com/license, requested: 2, used: 2, capacity: 3"))
+
+	// Other updates of the status are left alone
+	c := pods[2].DeepCopy()
+	c.Status.Phase = corev1.PodRunning
+	c, err = podClient.Pods("default").UpdateStatus(ctx, c, metav1.UpdateOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(c.Status.Phase, corev1.PodRunning))
+
+	// Pods without extended resources are not checked
+	p, podClient = newAdmittingProvider(mp, client.CoreV1(), recorder, n, nil)
+	assert.Check(t, is.Equal(p, provider.Provider(mp)))
+	_, ok = podClient.(admittingPodsGetter)
+	assert.Check(t, !ok, "the pods client is wrapped")
+}
+
+func TestPodRequests(t *testing.T) {
+	names := corev1.ResourceList{"example.com/gpu": resource.Quantity{}, "example.com/license": resource.Quantity{}}
+	req := func(kvs ...string) corev1.ResourceRequirements {
+		r := corev1.ResourceRequirements{Requests: corev1.ResourceList{}}
+		for i := 0; i < len(kvs); i += 2 {
+			r.Requests[corev1.ResourceName(kvs[i])] = resource.MustParse(kvs[i+1])
+		}
+		return r
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{
+			{Resources: req("example.com/gpu", "4")},
+			{Resources: req("example.com/license", "1")},
+		},
+		Containers: []corev1.Container{
+			{Resources: req("example.com/gpu", "1", "cpu", "1")},
+			{Resources: req("example.com/gpu", "2", "example.com/license", "2")},
+		},
+	}}
+	requests := podRequests(pod, names)
+	assert.Check(t, is.Len(requests, 2))
+	gpu, license := requests["example.com/gpu"], requests["example.com/license"]
+	assert.Check(t, is.Equal(gpu.String(), "4"))
+	assert.Check(t, is.Equal(license.String(), "2"))
+}
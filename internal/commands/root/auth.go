@@ -15,10 +15,12 @@
 package root
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
@@ -62,6 +64,59 @@ func NewVirtualKubeletAuth(authenticator authenticator.Request, authorizerAttrib
 	return &VirtualKubeletAuth{authenticator, authorizerAttributeGetter, authorizer}
 }
 
+// reloadableAuth is an AuthInterface whose implementation can be replaced
+// while it is in use, e.g. to change cache TTLs.
+type reloadableAuth struct {
+	mu   sync.RWMutex
+	auth AuthInterface
+	// stopCAReload stops reloading the client CA of auth.
+	stopCAReload chan struct{}
+}
+
+// set replaces the implementation with auth. runCAReload, as returned by
+// `BuildAuth`, is run until auth is replaced or `close` is called.
+func (r *reloadableAuth) set(auth AuthInterface, runCAReload func(<-chan struct{})) {
+	stop := make(chan struct{})
+	if runCAReload != nil {
+		runCAReload(stop)
+	}
+
+	r.mu.Lock()
+	if r.stopCAReload != nil {
+		close(r.stopCAReload)
+	}
+	r.auth, r.stopCAReload = auth, stop
+	r.mu.Unlock()
+}
+
+// close stops reloading the client CA.
+func (r *reloadableAuth) close() {
+	r.mu.Lock()
+	if r.stopCAReload != nil {
+		close(r.stopCAReload)
+		r.stopCAReload = nil
+	}
+	r.mu.Unlock()
+}
+
+func (r *reloadableAuth) get() AuthInterface {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.auth
+}
+
+func (r *reloadableAuth) AuthenticateRequest(req *http.Request) (*authenticator.Response, bool, error) {
+	return r.get().AuthenticateRequest(req)
+}
+
+func (r *reloadableAuth) GetRequestAttributes(u user.Info, req *http.Request) authorizer.Attributes {
+	return r.get().GetRequestAttributes(u, req)
+}
+
+func (r *reloadableAuth) Authorize(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+	return r.get().Authorize(ctx, a)
+}
+
 // BuildAuth creates an authenticator, an authorizer, and a matching authorizer attributes getter compatible with the virtual-kubelet's needs
 func BuildAuth(nodeName types.NodeName, client clientset.Interface, config opts.Opts) (AuthInterface, func(<-chan struct{}), error) {
 	// Get clients, if provided
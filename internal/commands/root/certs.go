@@ -0,0 +1,300 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// selfSignedCertValidity is how long a self-signed serving certificate is
+// valid for, the same as the kubelet's.
+const selfSignedCertValidity = 365 * 24 * time.Hour
+
+// certPollInterval is how often the serving certificate and client CA files
+// are checked for changes.
+var certPollInterval = 10 * time.Second
+
+// servingCerts holds the serving certificate and the client CA pool of the
+// pod API server, and reloads them when their files change, e.g. when
+// cert-manager rotates them.
+//
+// The TLS config returned by `config` always uses the current ones. A
+// change which fails to load, such as a certificate written before its key,
+// is logged and the current ones are kept until the files are fixed.
+//
+// Without a certificate file, the certificate is set with `setCert`, e.g.
+// to a self-signed one. A certificate issued by a `certificateRequester`
+// replaces the one of the file, which is only the fallback until then.
+type servingCerts struct {
+	certPath, keyPath, caPath string
+	// base is the TLS config without the certificate and CA pool.
+	base *tls.Config
+
+	mu       sync.RWMutex
+	certData []byte
+	keyData  []byte
+	caData   []byte
+	cert     *tls.Certificate
+	// issued is set once the certificate is issued through the certificates
+	// API, the certificate file is not reloaded anymore then.
+	issued bool
+	// clientConfig is base with the current CA pool, used for the
+	// handshakes once a client connects.
+	clientConfig *tls.Config
+}
+
+// newServingCerts loads the serving certificate and key if certPath is set,
+// and the client CA if caPath is set.
+func newServingCerts(base *tls.Config, certPath, keyPath, caPath string) (*servingCerts, error) {
+	s := &servingCerts{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+		base:     base,
+	}
+	if _, err := s.loadCert(); err != nil {
+		return nil, err
+	}
+	if _, err := s.loadCA(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// config returns the TLS config serving the current certificate and
+// verifying clients with the current CA pool.
+func (s *servingCerts) config() *tls.Config {
+	cfg := s.base.Clone()
+	cfg.GetCertificate = s.getCertificate
+	if s.caPath != "" {
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.clientConfig, nil
+		}
+	}
+	return cfg
+}
+
+func (s *servingCerts) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, errors.New("no serving certificate available yet")
+	}
+	return s.cert, nil
+}
+
+// leaf returns the current serving certificate, nil if there is none yet.
+func (s *servingCerts) leaf() *x509.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil
+	}
+	return s.cert.Leaf
+}
+
+// setCert replaces the serving certificate, its Leaf must be set.
+func (s *servingCerts) setCert(cert *tls.Certificate) {
+	s.mu.Lock()
+	s.cert = cert
+	s.mu.Unlock()
+}
+
+// setIssuedCert replaces the serving certificate with one issued through
+// the certificates API.
+func (s *servingCerts) setIssuedCert(cert *tls.Certificate) {
+	s.mu.Lock()
+	s.cert = cert
+	s.issued = true
+	s.mu.Unlock()
+}
+
+// run reloads the certificate and CA pool whenever their files change,
+// until ctx is cancelled.
+func (s *servingCerts) run(ctx context.Context) {
+	ticker := time.NewTicker(certPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.reload(ctx)
+	}
+}
+
+// reload loads the files which changed, logging every rotation.
+func (s *servingCerts) reload(ctx context.Context) {
+	logger := log.G(ctx)
+
+	if changed, err := s.loadCert(); err != nil {
+		logger.WithError(err).Error("Could not reload the serving certificate, keeping the current one")
+	} else if changed {
+		s.mu.RLock()
+		cert := s.cert.Leaf
+		s.mu.RUnlock()
+		logger.
+			WithField("certPath", s.certPath).
+			WithField("serial", cert.SerialNumber.String()).
+			WithField("notAfter", cert.NotAfter).
+			Info("Rotated the serving certificate")
+	}
+
+	if changed, err := s.loadCA(); err != nil {
+		logger.WithError(err).Error("Could not reload the client CA, keeping the current one")
+	} else if changed {
+		logger.WithField("caPath", s.caPath).Info("Rotated the client CA")
+	}
+}
+
+// loadCert loads the certificate and key if either file changed, and
+// reports whether they did.
+func (s *servingCerts) loadCert() (bool, error) {
+	s.mu.RLock()
+	issued := s.issued
+	s.mu.RUnlock()
+	if s.certPath == "" || issued {
+		return false, nil
+	}
+	certData, err := ioutil.ReadFile(s.certPath)
+	if err != nil {
+		return false, errors.Wrap(err, "error loading tls certs")
+	}
+	keyData, err := ioutil.ReadFile(s.keyPath)
+	if err != nil {
+		return false, errors.Wrap(err, "error loading tls certs")
+	}
+
+	s.mu.RLock()
+	unchanged := bytes.Equal(certData, s.certData) && bytes.Equal(keyData, s.keyData)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		return false, errors.Wrap(err, "error loading tls certs")
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, errors.Wrap(err, "error parsing the serving certificate")
+	}
+
+	s.mu.Lock()
+	s.certData, s.keyData, s.cert = certData, keyData, &cert
+	s.mu.Unlock()
+	return true, nil
+}
+
+// loadCA loads the client CA pool if its file changed, and reports whether
+// it did.
+func (s *servingCerts) loadCA() (bool, error) {
+	if s.caPath == "" {
+		return false, nil
+	}
+	caData, err := ioutil.ReadFile(s.caPath)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	unchanged := bytes.Equal(caData, s.caData)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caData) {
+		return false, errors.New("error appending ca cert to certificate pool")
+	}
+	clientConfig := s.base.Clone()
+	clientConfig.ClientCAs = caPool
+	clientConfig.GetCertificate = s.getCertificate
+
+	s.mu.Lock()
+	s.caData, s.clientConfig = caData, clientConfig
+	s.mu.Unlock()
+	return true, nil
+}
+
+// selfSignedCert generates a self-signed serving certificate for the node
+// name and addresses, which is only kept in memory.
+func selfSignedCert(nodeName string, addresses []corev1.NodeAddress) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating the private key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating the serial number")
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: fmt.Sprintf("%s@%d", nodeName, now.Unix()),
+		},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(selfSignedCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{nodeName},
+	}
+	dnsNames, ips := addressSANs(addresses)
+	for _, name := range dnsNames {
+		if name != nodeName {
+			tmpl.DNSNames = append(tmpl.DNSNames, name)
+		}
+	}
+	tmpl.IPAddresses = ips
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating the self-signed certificate")
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing the self-signed certificate")
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
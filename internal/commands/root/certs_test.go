@@ -0,0 +1,153 @@
+package root
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func newTestKeyPair(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NilError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestServingCertsReload(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "vk-certs")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath, caPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	write := func(path string, data []byte) {
+		assert.NilError(t, ioutil.WriteFile(path, data, 0600))
+	}
+
+	cert1, key1 := newTestKeyPair(t, "first")
+	ca1, _ := newTestKeyPair(t, "first-ca")
+	write(certPath, cert1)
+	write(keyPath, key1)
+	write(caPath, ca1)
+
+	s, err := newServingCerts(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}, certPath, keyPath, caPath)
+	assert.NilError(t, err)
+	cfg := s.config()
+
+	servedCN := func() string {
+		cfg, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+		assert.NilError(t, err)
+		cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+		assert.NilError(t, err)
+		return cert.Leaf.Subject.CommonName
+	}
+	clientCAs := func() *x509.CertPool {
+		cfg, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(cfg.ClientAuth, tls.RequireAndVerifyClientCert))
+		return cfg.ClientCAs
+	}
+	assert.Check(t, is.Equal(servedCN(), "first"))
+	firstCAs := clientCAs()
+
+	// Rotate the certificate and the CA
+	cert2, key2 := newTestKeyPair(t, "second")
+	ca2, _ := newTestKeyPair(t, "second-ca")
+	write(certPath, cert2)
+	write(keyPath, key2)
+	write(caPath, ca2)
+	s.reload(ctx)
+	assert.Check(t, is.Equal(servedCN(), "second"))
+	assert.Check(t, clientCAs() != firstCAs)
+
+	// A certificate which does not match the key yet is not served
+	cert3, _ := newTestKeyPair(t, "third")
+	write(certPath, cert3)
+	write(caPath, []byte("garbage"))
+	s.reload(ctx)
+	assert.Check(t, is.Equal(servedCN(), "second"))
+
+	// ... until the key is written too
+	secondCAs := clientCAs()
+	cert3, key3 := newTestKeyPair(t, "third")
+	write(certPath, cert3)
+	write(keyPath, key3)
+	s.reload(ctx)
+	assert.Check(t, is.Equal(servedCN(), "third"))
+	assert.Check(t, clientCAs() == secondCAs)
+}
+
+func TestServingCertsIssued(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "vk-certs")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	write := func(cn string) {
+		certPEM, keyPEM := newTestKeyPair(t, cn)
+		assert.NilError(t, ioutil.WriteFile(certPath, certPEM, 0600))
+		assert.NilError(t, ioutil.WriteFile(keyPath, keyPEM, 0600))
+	}
+	write("file")
+
+	s, err := newServingCerts(&tls.Config{}, certPath, keyPath, "")
+	assert.NilError(t, err)
+	servedCN := func() string {
+		cert, err := s.config().GetCertificate(&tls.ClientHelloInfo{})
+		assert.NilError(t, err)
+		return cert.Leaf.Subject.CommonName
+	}
+	// The file is served until a certificate is issued
+	assert.Check(t, is.Equal(servedCN(), "file"))
+
+	certPEM, keyPEM := newTestKeyPair(t, "issued")
+	issued, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NilError(t, err)
+	issued.Leaf, err = x509.ParseCertificate(issued.Certificate[0])
+	assert.NilError(t, err)
+	s.setIssuedCert(&issued)
+	assert.Check(t, is.Equal(servedCN(), "issued"))
+
+	// ... which is not replaced when the file changes
+	write("rotated")
+	s.reload(ctx)
+	assert.Check(t, is.Equal(servedCN(), "issued"))
+}
+
+func TestServingCertsLoadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-certs")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = newServingCerts(&tls.Config{}, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), "")
+	assert.ErrorContains(t, err, "error loading tls certs")
+}
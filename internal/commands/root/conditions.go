@@ -0,0 +1,320 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conditionCheckTimeoutReason is the reason of the condition of a check
+// which timed out.
+const conditionCheckTimeoutReason = "CheckTimeout"
+
+// servingCertCheckInterval is how often the serving certificate expiry is
+// checked.
+const servingCertCheckInterval = time.Minute
+
+// validateNodeConditionChecks checks the node condition checks registered
+// with the command.
+func validateNodeConditionChecks(checks []provider.NodeConditionCheck) error {
+	types := make(map[corev1.NodeConditionType]bool, len(checks))
+	for _, c := range checks {
+		if c.Type == "" {
+			return errdefs.InvalidInput("node condition check without a condition type")
+		}
+		if c.Check == nil {
+			return errdefs.InvalidInputf("node condition check %s has no check function", c.Type)
+		}
+		if c.Interval < 0 || c.Timeout < 0 {
+			return errdefs.InvalidInputf("node condition check %s: interval and timeout must not be negative", c.Type)
+		}
+		if types[c.Type] {
+			return errdefs.InvalidInputf("more than one node condition check for condition %s", c.Type)
+		}
+		types[c.Type] = true
+	}
+	return nil
+}
+
+// withBuiltinCheck returns checks with the built-in check added, unless a
+// check of the same type is registered.
+func withBuiltinCheck(checks []provider.NodeConditionCheck, builtin provider.NodeConditionCheck) []provider.NodeConditionCheck {
+	for _, c := range checks {
+		if c.Type == builtin.Type {
+			return checks
+		}
+	}
+	return append(append([]provider.NodeConditionCheck(nil), checks...), builtin)
+}
+
+// servingCertCheck returns the built-in check of the expiry of the current
+// serving certificate of certs.
+func servingCertCheck(certs *servingCerts) provider.NodeConditionCheck {
+	return provider.NodeConditionCheck{
+		Type:     provider.ServingCertificateExpiring,
+		Interval: servingCertCheckInterval,
+		Check: func(context.Context, string) provider.NodeConditionResult {
+			return checkCertExpiry(certs.leaf(), time.Now())
+		},
+	}
+}
+
+// checkCertExpiry sets the condition once less than a fifth of the validity
+// of cert is left, which is past the point the kubelet rotates its
+// certificates at.
+func checkCertExpiry(cert *x509.Certificate, now time.Time) provider.NodeConditionResult {
+	if cert == nil {
+		return provider.NodeConditionResult{
+			Status:  corev1.ConditionUnknown,
+			Reason:  "NoCertificate",
+			Message: "no serving certificate yet",
+		}
+	}
+	notAfter := cert.NotAfter.UTC().Format(time.RFC3339)
+	if !now.Before(cert.NotAfter) {
+		return provider.NodeConditionResult{
+			Status:  corev1.ConditionTrue,
+			Reason:  "CertificateExpired",
+			Message: "the serving certificate expired at " + notAfter,
+		}
+	}
+	if cert.NotAfter.Sub(now) < cert.NotAfter.Sub(cert.NotBefore)/5 {
+		return provider.NodeConditionResult{
+			Status:  corev1.ConditionTrue,
+			Reason:  "CertificateExpiring",
+			Message: "the serving certificate expires at " + notAfter,
+		}
+	}
+	return provider.NodeConditionResult{
+		Status:  corev1.ConditionFalse,
+		Reason:  "CertificateValid",
+		Message: "the serving certificate is valid until " + notAfter,
+	}
+}
+
+// conditionNodeProvider runs the node condition checks and merges their
+// conditions into the node status reported by the wrapped provider.
+type conditionNodeProvider struct {
+	node.NodeProvider
+	name   string
+	checks []provider.NodeConditionCheck
+
+	// changed is signalled when the status changes, the status is then
+	// sent by the goroutine started by NotifyNodeStatus.
+	changed chan struct{}
+
+	mu      sync.Mutex
+	node    *corev1.Node
+	results map[corev1.NodeConditionType]corev1.NodeCondition
+}
+
+// newConditionNodeProvider wraps p to add the conditions of checks to n, the
+// node as reported by the provider.
+// The checks are run once before returning, so the node is registered with
+// their conditions.
+func newConditionNodeProvider(ctx context.Context, p node.NodeProvider, n *corev1.Node, checks []provider.NodeConditionCheck) *conditionNodeProvider {
+	cp := &conditionNodeProvider{
+		NodeProvider: p,
+		name:         n.Name,
+		checks:       checks,
+		node:         n.DeepCopy(),
+		results:      make(map[corev1.NodeConditionType]corev1.NodeCondition, len(checks)),
+		changed:      make(chan struct{}, 1),
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c provider.NodeConditionCheck) {
+			defer wg.Done()
+			cp.set(ctx, c.Type, runConditionCheck(ctx, c, cp.name))
+		}(c)
+	}
+	wg.Wait()
+	return cp
+}
+
+// NotifyNodeStatus implements node.NodeProvider.
+//
+// The status is sent from a single goroutine until ctx is cancelled, so an
+// older status is never sent after a newer one, and neither the provider
+// nor the checks wait for cb, which blocks once the node controller stopped.
+func (p *conditionNodeProvider) NotifyNodeStatus(ctx context.Context, cb func(*corev1.Node)) {
+	p.NodeProvider.NotifyNodeStatus(ctx, func(n *corev1.Node) {
+		p.mu.Lock()
+		p.node = n.DeepCopy()
+		p.mu.Unlock()
+		p.signal()
+	})
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.changed:
+			}
+
+			p.mu.Lock()
+			merged := p.merged()
+			p.mu.Unlock()
+			cb(merged)
+		}
+	}()
+}
+
+// signal reports a change of the status, changes made while the previous
+// one is not sent yet are sent along with it.
+func (p *conditionNodeProvider) signal() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Node returns the node with the conditions of the checks.
+func (p *conditionNodeProvider) Node() *corev1.Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.merged()
+}
+
+// merged returns a copy of the node with the conditions of the checks
+// replacing the ones of the same type.
+// p.mu must be held.
+func (p *conditionNodeProvider) merged() *corev1.Node {
+	n := p.node.DeepCopy()
+	conditions := make([]corev1.NodeCondition, 0, len(n.Status.Conditions)+len(p.results))
+	for _, c := range n.Status.Conditions {
+		if _, ok := p.results[c.Type]; !ok {
+			conditions = append(conditions, c)
+		}
+	}
+	// Keep the order the checks were registered in.
+	for _, check := range p.checks {
+		if c, ok := p.results[check.Type]; ok {
+			conditions = append(conditions, c)
+		}
+	}
+	n.Status.Conditions = conditions
+	return n
+}
+
+// set records the result of a check. It reports whether the condition
+// changed, other than its heartbeat time.
+func (p *conditionNodeProvider) set(ctx context.Context, t corev1.NodeConditionType, r provider.NodeConditionResult) bool {
+	now := metav1.Now()
+	c := corev1.NodeCondition{
+		Type:               t,
+		Status:             r.Status,
+		Reason:             r.Reason,
+		Message:            r.Message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	old, ok := p.results[t]
+	if ok && old.Status == c.Status {
+		c.LastTransitionTime = old.LastTransitionTime
+	}
+	p.results[t] = c
+	if ok && old.Status != c.Status {
+		log.G(ctx).WithField("condition", t).WithField("status", c.Status).WithField("reason", c.Reason).Info("Node condition changed")
+	}
+	return !ok || old.Status != c.Status || old.Reason != c.Reason || old.Message != c.Message
+}
+
+// run runs every check at its interval until ctx is cancelled, and sends
+// the node status whenever a condition changes.
+func (p *conditionNodeProvider) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range p.checks {
+		wg.Add(1)
+		go func(c provider.NodeConditionCheck) {
+			defer wg.Done()
+			p.runCheck(ctx, c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+func (p *conditionNodeProvider) runCheck(ctx context.Context, c provider.NodeConditionCheck) {
+	interval := c.Interval
+	if interval == 0 {
+		interval = provider.DefaultNodeConditionCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r := runConditionCheck(ctx, c, p.name)
+		if ctx.Err() != nil {
+			return
+		}
+		if p.set(ctx, c.Type, r) {
+			p.signal()
+		}
+	}
+}
+
+// runConditionCheck runs c once, within its timeout.
+func runConditionCheck(ctx context.Context, c provider.NodeConditionCheck, name string) provider.NodeConditionResult {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = c.Interval
+	}
+	if timeout == 0 {
+		timeout = provider.DefaultNodeConditionCheckInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan provider.NodeConditionResult, 1)
+	go func() {
+		done <- c.Check(ctx, name)
+	}()
+	select {
+	case r := <-done:
+		if r.Status == "" {
+			r.Status = corev1.ConditionUnknown
+		}
+		return r
+	case <-ctx.Done():
+		return provider.NodeConditionResult{
+			Status:  corev1.ConditionUnknown,
+			Reason:  conditionCheckTimeoutReason,
+			Message: "the check did not complete within " + timeout.String(),
+		}
+	}
+}
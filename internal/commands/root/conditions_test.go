@@ -0,0 +1,266 @@
+package root
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type notifyingNodeProvider struct {
+	mu sync.Mutex
+	cb func(*corev1.Node)
+}
+
+func (p *notifyingNodeProvider) Ping(context.Context) error { return nil }
+
+func (p *notifyingNodeProvider) NotifyNodeStatus(_ context.Context, cb func(*corev1.Node)) {
+	p.mu.Lock()
+	p.cb = cb
+	p.mu.Unlock()
+}
+
+func (p *notifyingNodeProvider) notify(n *corev1.Node) {
+	p.mu.Lock()
+	cb := p.cb
+	p.mu.Unlock()
+	cb(n)
+}
+
+func conditionTypes(n *corev1.Node) []corev1.NodeConditionType {
+	var types []corev1.NodeConditionType
+	for _, c := range n.Status.Conditions {
+		types = append(types, c.Type)
+	}
+	return types
+}
+
+func findCondition(n *corev1.Node, t corev1.NodeConditionType) corev1.NodeCondition {
+	for _, c := range n.Status.Conditions {
+		if c.Type == t {
+			return c
+		}
+	}
+	return corev1.NodeCondition{}
+}
+
+func TestConditionNodeProviderMerge(t *testing.T) {
+	ctx := context.Background()
+
+	status := func(s corev1.ConditionStatus) func(context.Context, string) provider.NodeConditionResult {
+		return func(context.Context, string) provider.NodeConditionResult {
+			return provider.NodeConditionResult{Status: s, Reason: "Checked"}
+		}
+	}
+	n := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			{Type: "ProviderReachable", Status: corev1.ConditionUnknown},
+		}},
+	}
+	inner := &notifyingNodeProvider{}
+	cp := newConditionNodeProvider(ctx, inner, n, []provider.NodeConditionCheck{
+		{Type: "ProviderReachable", Check: status(corev1.ConditionTrue)},
+		{Type: "CertificateExpiring", Check: status(corev1.ConditionFalse)},
+	})
+
+	merged := cp.Node()
+	assert.Check(t, is.DeepEqual(conditionTypes(merged), []corev1.NodeConditionType{corev1.NodeReady, "ProviderReachable", "CertificateExpiring"}))
+	assert.Check(t, is.Equal(findCondition(merged, "ProviderReachable").Status, corev1.ConditionTrue))
+	assert.Check(t, is.Equal(findCondition(merged, "CertificateExpiring").Reason, "Checked"))
+
+	// Updates from the provider keep the conditions of the checks
+	updates := make(chan *corev1.Node, 1)
+	cp.NotifyNodeStatus(ctx, func(n *corev1.Node) { updates <- n })
+	update := n.DeepCopy()
+	update.Status.Conditions[0].Status = corev1.ConditionFalse
+	inner.notify(update)
+	var got *corev1.Node
+	select {
+	case got = <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the node status update")
+	}
+	assert.Check(t, is.Equal(findCondition(got, corev1.NodeReady).Status, corev1.ConditionFalse))
+	assert.Check(t, is.Equal(findCondition(got, "ProviderReachable").Status, corev1.ConditionTrue))
+}
+
+func TestConditionNodeProviderRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	result := provider.NodeConditionResult{Status: corev1.ConditionTrue}
+	check := provider.NodeConditionCheck{
+		Type:     "ProviderReachable",
+		Interval: 10 * time.Millisecond,
+		Check: func(context.Context, string) provider.NodeConditionResult {
+			mu.Lock()
+			defer mu.Unlock()
+			return result
+		},
+	}
+	cp := newConditionNodeProvider(ctx, &notifyingNodeProvider{}, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}, []provider.NodeConditionCheck{check})
+	transition := findCondition(cp.Node(), check.Type).LastTransitionTime
+
+	updates := make(chan *corev1.Node, 1)
+	cp.NotifyNodeStatus(ctx, func(n *corev1.Node) { updates <- n })
+	go cp.run(ctx)
+
+	mu.Lock()
+	result = provider.NodeConditionResult{Status: corev1.ConditionFalse, Reason: "Unreachable"}
+	mu.Unlock()
+
+	select {
+	case n := <-updates:
+		c := findCondition(n, check.Type)
+		assert.Check(t, is.Equal(c.Status, corev1.ConditionFalse))
+		assert.Check(t, is.Equal(c.Reason, "Unreachable"))
+		assert.Check(t, !c.LastTransitionTime.Before(&transition))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the node status update")
+	}
+}
+
+func TestConditionNodeProviderBlockedNotify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	checked := make(chan corev1.ConditionStatus)
+	statuses := []corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse}
+	var calls int
+	check := provider.NodeConditionCheck{
+		Type:     "ProviderReachable",
+		Interval: time.Millisecond,
+		Check: func(ctx context.Context, _ string) provider.NodeConditionResult {
+			// Every result is a change, which notifies
+			s := statuses[calls%2]
+			calls++
+			select {
+			case checked <- s:
+			case <-ctx.Done():
+			}
+			return provider.NodeConditionResult{Status: s}
+		},
+	}
+	go func() { <-checked }()
+	inner := &notifyingNodeProvider{}
+	cp := newConditionNodeProvider(ctx, inner, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}}, []provider.NodeConditionCheck{check})
+
+	// Like the callback of a node controller which stopped, nothing receives
+	// the updates.
+	block := make(chan *corev1.Node)
+	cp.NotifyNodeStatus(ctx, func(n *corev1.Node) { block <- n })
+	go cp.run(ctx)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-checked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the checks are blocked by the status update")
+		}
+	}
+	// Nor are updates from the provider
+	done := make(chan struct{})
+	go func() {
+		inner.notify(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider is blocked by the status update")
+	}
+}
+
+func TestRunConditionCheck(t *testing.T) {
+	ctx := context.Background()
+
+	r := runConditionCheck(ctx, provider.NodeConditionCheck{
+		Type:    "Slow",
+		Timeout: 10 * time.Millisecond,
+		Check: func(ctx context.Context, _ string) provider.NodeConditionResult {
+			<-ctx.Done()
+			return provider.NodeConditionResult{Status: corev1.ConditionTrue}
+		},
+	}, "node")
+	assert.Check(t, is.Equal(r.Status, corev1.ConditionUnknown))
+	assert.Check(t, is.Equal(r.Reason, conditionCheckTimeoutReason))
+
+	r = runConditionCheck(ctx, provider.NodeConditionCheck{
+		Type: "Empty",
+		Check: func(_ context.Context, name string) provider.NodeConditionResult {
+			return provider.NodeConditionResult{Message: name}
+		},
+	}, "node")
+	assert.Check(t, is.Equal(r.Status, corev1.ConditionUnknown))
+	assert.Check(t, is.Equal(r.Message, "node"))
+}
+
+func TestValidateNodeConditionChecks(t *testing.T) {
+	check := func(context.Context, string) provider.NodeConditionResult { return provider.NodeConditionResult{} }
+
+	assert.NilError(t, validateNodeConditionChecks(nil))
+	assert.NilError(t, validateNodeConditionChecks([]provider.NodeConditionCheck{{Type: "A", Check: check}, {Type: "B", Check: check}}))
+
+	for _, checks := range [][]provider.NodeConditionCheck{
+		{{Check: check}},
+		{{Type: "A"}},
+		{{Type: "A", Check: check, Interval: -time.Second}},
+		{{Type: "A", Check: check}, {Type: "A", Check: check}},
+	} {
+		err := validateNodeConditionChecks(checks)
+		assert.Check(t, errdefs.IsInvalidInput(err), "%v", err)
+	}
+}
+
+func TestCheckCertExpiry(t *testing.T) {
+	notBefore := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(100 * 24 * time.Hour)}
+
+	for _, tc := range []struct {
+		name   string
+		cert   *x509.Certificate
+		now    time.Time
+		status corev1.ConditionStatus
+		reason string
+	}{
+		{"no certificate", nil, notBefore, corev1.ConditionUnknown, "NoCertificate"},
+		{"valid", cert, notBefore.Add(79 * 24 * time.Hour), corev1.ConditionFalse, "CertificateValid"},
+		{"expiring", cert, notBefore.Add(81 * 24 * time.Hour), corev1.ConditionTrue, "CertificateExpiring"},
+		{"expired", cert, cert.NotAfter, corev1.ConditionTrue, "CertificateExpired"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := checkCertExpiry(tc.cert, tc.now)
+			assert.Check(t, is.Equal(r.Status, tc.status))
+			assert.Check(t, is.Equal(r.Reason, tc.reason))
+		})
+	}
+}
+
+func TestWithBuiltinCheck(t *testing.T) {
+	certs := &servingCerts{}
+	registered := []provider.NodeConditionCheck{{Type: "ProviderReachable"}}
+
+	checks := withBuiltinCheck(registered, servingCertCheck(certs))
+	assert.Assert(t, is.Len(checks, 2))
+	assert.Check(t, is.Equal(checks[1].Type, provider.ServingCertificateExpiring))
+	assert.Check(t, is.Len(registered, 1))
+	r := checks[1].Check(context.Background(), "node")
+	assert.Check(t, is.Equal(r.Status, corev1.ConditionUnknown))
+
+	// A registered check of the same type replaces the built-in one
+	registered = append(registered, provider.NodeConditionCheck{Type: provider.ServingCertificateExpiring})
+	checks = withBuiltinCheck(registered, servingCertCheck(certs))
+	assert.Check(t, is.Len(checks, 2))
+	assert.Check(t, checks[1].Check == nil)
+}
@@ -0,0 +1,145 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// resolveOpts layers the config file and the environment on top of the
+// already parsed flags.
+//
+// Precedence, from highest to lowest, is:
+//  1. flags explicitly set on the command line
+//  2. `VK_<FLAG>` environment variables
+//  3. legacy environment variables, see `envAliases`
+//  4. the config file passed with `--config`
+//  5. the KubeletConfiguration file passed with `--kubelet-config`
+//  6. defaults, i.e. whatever was in the options before flags were parsed
+//
+// flags must be bound to o.
+func resolveOpts(ctx context.Context, flags *pflag.FlagSet, o *opts.Opts) error {
+	_, err := resolveOptsWithSources(ctx, flags, o)
+	return err
+}
+
+// optsSources records what `resolveOpts` used to resolve the options.
+type optsSources struct {
+	// fromEnv maps flag names to the environment variable they were set from.
+	fromEnv map[string]string
+	// configs are the config files applied, from lowest to highest
+	// precedence.
+	configs []*opts.Config
+}
+
+// resolveOptsWithSources is `resolveOpts`, but also returns where the
+// options came from.
+func resolveOptsWithSources(ctx context.Context, flags *pflag.FlagSet, o *opts.Opts) (*optsSources, error) {
+	// The environment is applied first so the config file can be set with
+	// an environment variable too.
+	fromEnv, err := applyEnv(ctx, flags)
+	if err != nil {
+		return nil, err
+	}
+	src := &optsSources{fromEnv: fromEnv}
+
+	if o.KubeletConfigPath != "" {
+		cfg, unsupported, err := opts.LoadKubeletConfig(o.KubeletConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range unsupported {
+			log.G(ctx).WithField("kubeletConfig", o.KubeletConfigPath).Warnf("KubeletConfiguration field %s is not supported, ignoring it", f)
+		}
+		src.configs = append(src.configs, cfg)
+	}
+	if o.ConfigPath != "" {
+		cfg, err := opts.LoadConfig(o.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		src.configs = append(src.configs, cfg)
+	}
+
+	for _, cfg := range src.configs {
+		if err := cfg.Apply(o, src.overridden(flags)); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// overridden returns a function reporting whether a flag was set on the
+// command line or from the environment, and so takes precedence over the
+// config file.
+func (s *optsSources) overridden(flags *pflag.FlagSet) func(string) bool {
+	return func(name string) bool {
+		f := flags.Lookup(name)
+		return f != nil && f.Changed || s.fromEnv[name] != ""
+	}
+}
+
+// optsLoader resolves the options from scratch using the same precedence as
+// `resolveOpts`.
+// This is used to pick up changes to the config file while running.
+type optsLoader struct {
+	// defaults holds the options as they were before flags were parsed.
+	defaults opts.Opts
+	// flags is the flag set which was parsed from the command line.
+	flags *pflag.FlagSet
+}
+
+func (l *optsLoader) load(ctx context.Context) (*opts.Opts, error) {
+	o := l.defaults
+
+	fs := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+	installFlags(fs, &o)
+	if err := copyFlags(fs, l.flags); err != nil {
+		return nil, err
+	}
+
+	if err := resolveOpts(ctx, fs, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// copyFlags sets the values of all the flags set in src on the matching flags
+// in dst, and marks them as set.
+func copyFlags(dst, src *pflag.FlagSet) error {
+	var err error
+	src.Visit(func(f *pflag.Flag) {
+		d := dst.Lookup(f.Name)
+		if d == nil || err != nil {
+			return
+		}
+		if _, ok := f.Value.(aliasValue); ok {
+			// The flag it is an alias of is copied
+			return
+		}
+
+		d.Changed = true
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			err = d.Value.(pflag.SliceValue).Replace(sv.GetSlice())
+			return
+		}
+		err = d.Value.Set(f.Value.String())
+	})
+	return err
+}
@@ -0,0 +1,167 @@
+package root
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestResolveOptsPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-config")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configPath, []byte(`
+nodeName: from-file
+taintKey: from-file
+taintValue: from-file
+kubeClusterDomain: from-file
+nodeLabels: [from=file]
+nodeAnnotations: [from=file]
+registerWithTaints: ["from=file:NoSchedule"]
+`), 0600)
+	assert.NilError(t, err)
+
+	os.Setenv("DEFAULTNODE_NAME", "from-env")
+	defer os.Unsetenv("DEFAULTNODE_NAME")
+	os.Setenv("VKUBELET_TAINT_KEY", "from-env")
+	defer os.Unsetenv("VKUBELET_TAINT_KEY")
+	os.Setenv("VK_NODE_ANNOTATIONS", "from=env,other=env")
+	defer os.Unsetenv("VK_NODE_ANNOTATIONS")
+	os.Setenv("VK_NODE_LABELS", "from=env")
+	defer os.Unsetenv("VK_NODE_LABELS")
+
+	o := opts.New()
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	err = flags.Parse([]string{"--config", configPath, "--nodename", "from-flag", "--node-labels", "from=flag,a=1", "--node-labels", "b=2"})
+	assert.NilError(t, err)
+
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+
+	// flag > env > file > default
+	assert.Check(t, is.Equal(o.NodeName, "from-flag"))
+	assert.Check(t, is.DeepEqual(o.NodeLabels, []string{"from=flag", "a=1", "b=2"}))
+	// env > file > default
+	assert.Check(t, is.Equal(o.TaintKey, "from-env"))
+	assert.Check(t, is.DeepEqual(o.NodeAnnotations, []string{"from=env", "other=env"}))
+	// file > default
+	assert.Check(t, is.Equal(o.TaintValue, "from-file"))
+	assert.Check(t, is.Equal(o.KubeClusterDomain, "from-file"))
+	assert.Check(t, is.DeepEqual(o.RegisterWithTaints, []string{"from=file:NoSchedule"}))
+	// default
+	assert.Check(t, is.Equal(o.PodSyncWorkers, opts.DefaultPodSyncWorkers))
+}
+
+func TestResolveOptsKubeletConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-config")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	kubeletConfigPath := filepath.Join(dir, "kubelet.yaml")
+	err = ioutil.WriteFile(kubeletConfigPath, []byte(`
+apiVersion: kubelet.config.k8s.io/v1beta1
+kind: KubeletConfiguration
+port: 10251
+clusterDomain: from-kubelet-config
+kubeAPIQPS: 20
+`), 0600)
+	assert.NilError(t, err)
+	configPath := filepath.Join(dir, "config.yaml")
+	assert.NilError(t, ioutil.WriteFile(configPath, []byte("kubeClusterDomain: from-file\n"), 0600))
+
+	o := opts.New()
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	err = flags.Parse([]string{"--config", configPath, "--kubelet-config", kubeletConfigPath, "--kube-api-qps", "5"})
+	assert.NilError(t, err)
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+
+	assert.Check(t, is.Equal(o.ListenPort, int32(10251)))
+	// config file > kubelet config
+	assert.Check(t, is.Equal(o.KubeClusterDomain, "from-file"))
+	// flag > kubelet config
+	assert.Check(t, is.Equal(o.KubeAPIQPS, int32(5)))
+}
+
+func TestResolveOptsKubeletConfigAnonymous(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-config")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	caPath := filepath.Join(dir, "ca.pem")
+	ca, _ := newTestKeyPair(t, "ca")
+	assert.NilError(t, ioutil.WriteFile(caPath, ca, 0600))
+	kubeletConfigPath := filepath.Join(dir, "kubelet.yaml")
+	err = ioutil.WriteFile(kubeletConfigPath, []byte(`
+apiVersion: kubelet.config.k8s.io/v1beta1
+kind: KubeletConfiguration
+authentication:
+  anonymous:
+    enabled: true
+  x509:
+    clientCAFile: `+caPath+`
+`), 0600)
+	assert.NilError(t, err)
+
+	o := opts.New()
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	assert.NilError(t, flags.Parse([]string{"--kubelet-config", kubeletConfigPath}))
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+	assert.Check(t, !o.AllowUnauthenticatedClients)
+
+	// Clients must still present a certificate signed by the CA
+	cfg, err := getAPIConfig(o)
+	assert.NilError(t, err)
+	certs, err := loadTLSConfig(context.Background(), cfg)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(certs.base.ClientAuth, tls.RequireAndVerifyClientCert))
+	clientConfig, err := certs.config().GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.NilError(t, err)
+	assert.Check(t, clientConfig.ClientCAs != nil)
+}
+
+func TestResolveOptsFlagAlias(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-config")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.yaml")
+	assert.NilError(t, ioutil.WriteFile(configPath, []byte("listenAddresses: [10.0.0.9]\n"), 0600))
+	setEnv(t, "VK_ADDRESS", "10.0.0.8")
+
+	o := opts.New()
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	assert.Check(t, is.Len(flags.Lookup("listen-addr").Annotations[envAnnotation], 0), "the alias has its own environment variable")
+	err = flags.Parse([]string{"--config", configPath, "--address", "10.0.0.1", "--listen-addr", "10.0.0.2,10.0.0.3", "--address", "10.0.0.4"})
+	assert.NilError(t, err)
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+
+	// Both flags add to the same list, which wins over env and file
+	assert.Check(t, is.DeepEqual(o.ListenAddresses, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}))
+
+	o = opts.New()
+	flags = pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	assert.NilError(t, flags.Parse([]string{"--config", configPath, "--listen-addr", "10.0.0.2"}))
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+	assert.Check(t, flags.Changed("address"))
+	assert.Check(t, is.DeepEqual(o.ListenAddresses, []string{"10.0.0.2"}))
+
+	o = opts.New()
+	flags = pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	assert.NilError(t, flags.Parse([]string{"--system-reserved", "cpu=1", "--reserved", "memory=1Gi"}))
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+	assert.Check(t, is.DeepEqual(o.SystemReserved, []string{"cpu=1", "memory=1Gi"}))
+}
@@ -0,0 +1,214 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/yaml"
+)
+
+// Sources of a config value, from highest to lowest precedence.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+const redacted = "<redacted>"
+
+// configValue is a single option as printed by `config view`.
+type configValue struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// newConfigCommand creates the config subcommand.
+func newConfigCommand(o *opts.Opts, flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newConfigViewCommand(o, flags))
+	return cmd
+}
+
+// newConfigViewCommand creates the config view subcommand.
+// The options are resolved exactly like the root command does, from the
+// flags shared with the root command, the environment and the config file.
+func newConfigViewCommand(o *opts.Opts, flags *pflag.FlagSet) *cobra.Command {
+	var (
+		output        string
+		showSensitive bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "view",
+		Short: "Print the effective configuration",
+		Long: `Resolve the configuration from flags, environment variables and the config
+file and print it in the config file format.
+Every value is annotated with where it came from: flag, env, file or default.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := resolveOptsWithSources(cmd.Context(), cmd.Flags(), o)
+			if err != nil {
+				return err
+			}
+			view := viewConfig(cmd.Flags(), o, src, showSensitive)
+
+			var data []byte
+			switch output {
+			case "yaml":
+				data, err = yaml.Marshal(view)
+			case "json":
+				data, err = json.MarshalIndent(view, "", "  ")
+				data = append(data, '\n')
+			default:
+				return errdefs.InvalidInputf("unsupported output format %q, must be one of yaml, json", output)
+			}
+			if err != nil {
+				return errors.Wrap(err, "error encoding configuration")
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "yaml", "output format, one of yaml, json")
+	cmd.Flags().BoolVar(&showSensitive, "show-sensitive", false, "do not redact credentials and their locations")
+	cmd.Flags().AddFlagSet(flags)
+	return cmd
+}
+
+// viewConfig returns the resolved options keyed like the config file, with
+// every value annotated with its source.
+func viewConfig(flags *pflag.FlagSet, o *opts.Opts, src *optsSources, showSensitive bool) map[string]interface{} {
+	cfgs := make([]reflect.Value, 0, len(src.configs))
+	for _, c := range src.configs {
+		cfgs = append(cfgs, reflect.ValueOf(c).Elem())
+	}
+
+	source := func(flagName string, inFile bool) string {
+		if flagName != "" {
+			if f := flags.Lookup(flagName); f != nil && f.Changed {
+				return sourceFlag
+			}
+			if src.fromEnv[flagName] != "" {
+				return sourceEnv
+			}
+		}
+		if inFile {
+			return sourceFile
+		}
+		return sourceDefault
+	}
+
+	return viewFields(reflect.TypeOf(opts.Config{}), cfgs, reflect.ValueOf(o).Elem(), source, showSensitive)
+}
+
+var (
+	configDurationType = reflect.TypeOf(metav1.Duration{})
+	rateLimiterType    = reflect.TypeOf((*workqueue.RateLimiter)(nil)).Elem()
+)
+
+// viewFields returns the fields of type t from o, cfgs are the config file
+// values of type t which are set.
+func viewFields(t reflect.Type, cfgs []reflect.Value, o reflect.Value, source func(string, bool) string, showSensitive bool) map[string]interface{} {
+	out := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		v := o.FieldByName(f.Name)
+
+		// inFile holds the values set in the config files.
+		var inFile []reflect.Value
+		for _, cfg := range cfgs {
+			if !cfg.Field(i).IsNil() {
+				inFile = append(inFile, reflect.Indirect(cfg.Field(i)))
+			}
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && ft != configDurationType {
+			out[name] = viewFields(ft, inFile, v, source, showSensitive)
+			continue
+		}
+
+		value := viewValue(v)
+		if !showSensitive {
+			if f.Tag.Get("sensitive") == "true" && !v.IsZero() {
+				value = redacted
+			} else if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct {
+				value = redactElems(v)
+			}
+		}
+		out[name] = configValue{Value: value, Source: source(f.Tag.Get("flag"), len(inFile) > 0)}
+	}
+	return out
+}
+
+// redactElems returns a copy of the slice of structs v with the sensitive
+// fields of every element redacted.
+func redactElems(v reflect.Value) interface{} {
+	c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(c, v)
+	t := v.Type().Elem()
+	for i := 0; i < c.Len(); i++ {
+		e := c.Index(i)
+		for j := 0; j < t.NumField(); j++ {
+			f := e.Field(j)
+			if t.Field(j).Tag.Get("sensitive") == "true" && f.Kind() == reflect.String && !f.IsZero() {
+				f.SetString(redacted)
+			}
+		}
+	}
+	return c.Interface()
+}
+
+// viewValue converts an option to the value used in the config file.
+func viewValue(v reflect.Value) interface{} {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		return time.Duration(v.Int()).String()
+	case v.Type() == configDurationType:
+		return v.Interface().(metav1.Duration).Duration.String()
+	case v.Type() == rateLimiterType:
+		if v.IsNil() {
+			return nil
+		}
+		if spec := opts.RateLimiterSpec(v.Interface().(workqueue.RateLimiter)); spec != "" {
+			return spec
+		}
+		return "<custom>"
+	default:
+		return v.Interface()
+	}
+}
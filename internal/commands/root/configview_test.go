@@ -0,0 +1,85 @@
+package root
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	"sigs.k8s.io/yaml"
+)
+
+func TestConfigViewCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-config-view")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(configPath, []byte(`
+kubeClusterDomain: from-file
+podSyncWorkers: 3
+tlsCertFile: /secret/cert.pem
+nodes:
+- name: a
+  providerConfigPath: /secret/a.json
+- name: b
+authentication:
+  webhook:
+    cacheTTL: 1m
+`), 0600)
+	assert.NilError(t, err)
+
+	setEnv(t, "VK_POD_SYNC_WORKERS", "5")
+
+	run := func(args ...string) string {
+		cmd := NewCommand("vk", provider.NewStore(), opts.New(), Extensions{})
+		var stdout bytes.Buffer
+		cmd.SetOut(&stdout)
+		cmd.SetArgs(append([]string{"config", "view", "--config", configPath, "--nodename", "from-flag"}, args...))
+		assert.NilError(t, cmd.Execute())
+		return stdout.String()
+	}
+
+	type value struct {
+		Value  interface{} `json:"value"`
+		Source string      `json:"source"`
+	}
+	var view struct {
+		NodeName          value `json:"nodeName"`
+		PodSyncWorkers    value `json:"podSyncWorkers"`
+		KubeClusterDomain value `json:"kubeClusterDomain"`
+		KubeNamespace     value `json:"kubeNamespace"`
+		TLSCertFile       value `json:"tlsCertFile"`
+		Authentication    struct {
+			Webhook struct {
+				CacheTTL value `json:"cacheTTL"`
+			} `json:"webhook"`
+		} `json:"authentication"`
+		SyncPodsFromKubernetesRateLimiter value `json:"syncPodsFromKubernetesRateLimiter"`
+		Nodes                             struct {
+			Value  []opts.NodeSpec `json:"value"`
+			Source string          `json:"source"`
+		} `json:"nodes"`
+	}
+
+	assert.NilError(t, json.Unmarshal([]byte(run("-o", "json")), &view))
+	assert.Check(t, is.DeepEqual(view.NodeName, value{"from-flag", sourceFlag}))
+	assert.Check(t, is.DeepEqual(view.PodSyncWorkers, value{float64(5), sourceEnv}))
+	assert.Check(t, is.DeepEqual(view.KubeClusterDomain, value{"from-file", sourceFile}))
+	assert.Check(t, is.DeepEqual(view.KubeNamespace, value{"", sourceDefault}))
+	assert.Check(t, is.DeepEqual(view.TLSCertFile, value{redacted, sourceFile}))
+	assert.Check(t, is.DeepEqual(view.Authentication.Webhook.CacheTTL, value{"1m0s", sourceFile}))
+	assert.Check(t, is.DeepEqual(view.SyncPodsFromKubernetesRateLimiter, value{opts.DefaultRateLimiterSpec, sourceDefault}))
+	assert.Check(t, is.DeepEqual(view.Nodes.Value, []opts.NodeSpec{{Name: "a", ProviderConfigPath: redacted}, {Name: "b"}}))
+	assert.Check(t, is.Equal(view.Nodes.Source, sourceFile))
+
+	assert.NilError(t, yaml.Unmarshal([]byte(run("--show-sensitive")), &view))
+	assert.Check(t, is.DeepEqual(view.TLSCertFile, value{"/secret/cert.pem", sourceFile}))
+	assert.Check(t, is.Equal(view.Nodes.Value[0].ProviderConfigPath, "/secret/a.json"))
+}
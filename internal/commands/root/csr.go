@@ -0,0 +1,272 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	mathrand "math/rand"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	certificatesv1client "k8s.io/client-go/kubernetes/typed/certificates/v1"
+)
+
+var (
+	// csrPollInterval is how often a pending CertificateSigningRequest is
+	// checked for the issued certificate.
+	csrPollInterval = 5 * time.Second
+	// csrRetryInterval is how long to wait before requesting a certificate
+	// again after a failure. It doubles with every consecutive failure, up
+	// to csrMaxRetryInterval.
+	csrRetryInterval    = 10 * time.Second
+	csrMaxRetryInterval = 5 * time.Minute
+)
+
+// certificateRequester gets the serving certificate of a node from the
+// `kubernetes.io/kubelet-serving` signer through the certificates API, like
+// the kubelet does with `--rotate-server-certificates`.
+//
+// The private key never leaves the process, so every start requests a new
+// certificate. The CertificateSigningRequests of the previous certificates
+// are deleted once a new one is issued.
+type certificateRequester struct {
+	csrs      certificatesv1client.CertificateSigningRequestInterface
+	nodeName  string
+	addresses []corev1.NodeAddress
+	// issued is closed once the first certificate is issued.
+	issued chan struct{}
+}
+
+func newCertificateRequester(csrs certificatesv1client.CertificateSigningRequestInterface, nodeName string, addresses []corev1.NodeAddress) *certificateRequester {
+	return &certificateRequester{
+		csrs:      csrs,
+		nodeName:  nodeName,
+		addresses: addresses,
+		issued:    make(chan struct{}),
+	}
+}
+
+// Issued returns a channel which is closed once the first certificate is
+// issued.
+func (r *certificateRequester) Issued() <-chan struct{} {
+	return r.issued
+}
+
+// run keeps s serving a valid certificate until ctx is cancelled: it
+// requests one, and requests a new one once most of its lifetime has
+// passed.
+func (r *certificateRequester) run(ctx context.Context, s *servingCerts) {
+	retry := csrRetryInterval
+	// previous are the CertificateSigningRequests created before the one of
+	// the current certificate.
+	var previous []string
+	for {
+		cert, name, err := r.request(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		next := retry
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("retryIn", retry).Error("Could not get a serving certificate from the certificates API")
+			retry *= 2
+			if retry > csrMaxRetryInterval {
+				retry = csrMaxRetryInterval
+			}
+			if name != "" {
+				previous = append(previous, name)
+			}
+		} else {
+			s.setIssuedCert(cert)
+			select {
+			case <-r.issued:
+			default:
+				close(r.issued)
+			}
+			log.G(ctx).
+				WithField("serial", cert.Leaf.SerialNumber.String()).
+				WithField("notAfter", cert.Leaf.NotAfter).
+				Info("Rotated the serving certificate")
+			r.deleteCSRs(ctx, previous)
+			previous = []string{name}
+			retry = csrRetryInterval
+			next = time.Until(rotationDeadline(cert.Leaf))
+		}
+
+		t := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// rotationDeadline returns when to request a new certificate to replace
+// cert: at a random point between 70% and 90% of its lifetime, as the
+// kubelet does, so many nodes do not renew all at once.
+func rotationDeadline(cert *x509.Certificate) time.Time {
+	lifetime := float64(cert.NotAfter.Sub(cert.NotBefore))
+	jittered := time.Duration(lifetime * (0.7 + 0.2*mathrand.Float64()))
+	return cert.NotBefore.Add(jittered)
+}
+
+// deleteCSRs deletes the named CertificateSigningRequests, logging the
+// ones which could not be deleted.
+func (r *certificateRequester) deleteCSRs(ctx context.Context, names []string) {
+	for _, name := range names {
+		if err := r.csrs.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			log.G(ctx).WithError(err).WithField("csr", name).Warn("Could not delete the certificate signing request")
+		}
+	}
+}
+
+// request creates a CertificateSigningRequest for a new key and waits for
+// the certificate to be issued. The name of the request is returned once it
+// is created, also along with an error.
+func (r *certificateRequester) request(ctx context.Context) (*tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error generating the private key")
+	}
+	csrPEM, err := r.certificateRequest(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	csr, err := r.csrs.Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "csr-" + r.nodeName + "-" + utilrand.String(5),
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    csrPEM,
+			SignerName: certificatesv1.KubeletServingSignerName,
+			Usages: []certificatesv1.KeyUsage{
+				certificatesv1.UsageDigitalSignature,
+				certificatesv1.UsageKeyEncipherment,
+				certificatesv1.UsageServerAuth,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error creating the certificate signing request")
+	}
+	log.G(ctx).WithField("csr", csr.Name).Info("Requested a serving certificate, waiting for it to be issued")
+
+	certPEM, err := r.waitForCertificate(ctx, csr.Name)
+	if err != nil {
+		return nil, csr.Name, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, csr.Name, errors.Wrap(err, "error encoding the private key")
+	}
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		return nil, csr.Name, errors.Wrapf(err, "invalid certificate issued for %s", csr.Name)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, csr.Name, errors.Wrapf(err, "invalid certificate issued for %s", csr.Name)
+	}
+	return &cert, csr.Name, nil
+}
+
+// certificateRequest returns the PEM encoded certificate request for key,
+// with the subject the kubelet-serving signer requires and the node
+// addresses as subject alternative names.
+func (r *certificateRequester) certificateRequest(key *ecdsa.PrivateKey) ([]byte, error) {
+	tmpl := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   "system:node:" + r.nodeName,
+			Organization: []string{"system:nodes"},
+		},
+	}
+	tmpl.DNSNames, tmpl.IPAddresses = addressSANs(r.addresses)
+	if len(tmpl.DNSNames) == 0 && len(tmpl.IPAddresses) == 0 {
+		return nil, errors.Errorf("node %s has no addresses to request a serving certificate for", r.nodeName)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating the certificate request")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// addressSANs returns the DNS names and IP addresses of a node, to use as
+// the subject alternative names of its serving certificate.
+func addressSANs(addresses []corev1.NodeAddress) ([]string, []net.IP) {
+	var (
+		dnsNames []string
+		ips      []net.IP
+	)
+	seen := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		if a.Address == "" || seen[a.Address] {
+			continue
+		}
+		seen[a.Address] = true
+
+		switch a.Type {
+		case corev1.NodeHostName, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+			dnsNames = append(dnsNames, a.Address)
+		case corev1.NodeInternalIP, corev1.NodeExternalIP:
+			if ip := net.ParseIP(a.Address); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return dnsNames, ips
+}
+
+// waitForCertificate waits for the certificate of the named
+// CertificateSigningRequest to be issued, and returns it.
+func (r *certificateRequester) waitForCertificate(ctx context.Context, name string) ([]byte, error) {
+	var certPEM []byte
+	err := wait.PollImmediateUntil(csrPollInterval, func() (bool, error) {
+		csr, err := r.csrs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("csr", name).Warn("Could not get the certificate signing request")
+			return false, nil
+		}
+		for _, c := range csr.Status.Conditions {
+			switch c.Type {
+			case certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+				return false, errors.Errorf("certificate signing request %s: %s: %s", name, c.Reason, c.Message)
+			}
+		}
+		certPEM = csr.Status.Certificate
+		return len(certPEM) > 0, nil
+	}, ctx.Done())
+	return certPEM, err
+}
@@ -0,0 +1,236 @@
+package root
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	certificatesv1client "k8s.io/client-go/kubernetes/typed/certificates/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// signCSRs plays the signer: it issues a certificate for every pending
+// CertificateSigningRequest, or denies them if deny is set.
+// The certificates are valid for validity.
+func signCSRs(ctx context.Context, t *testing.T, csrs certificatesv1client.CertificateSigningRequestInterface, deny bool, validity time.Duration) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	for ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+		list, err := csrs.List(ctx, metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+		for i := range list.Items {
+			csr := &list.Items[i]
+			if len(csr.Status.Certificate) > 0 || len(csr.Status.Conditions) > 0 {
+				continue
+			}
+			if deny {
+				csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+					Type: certificatesv1.CertificateDenied, Reason: "NotAllowed", Message: "go away",
+				})
+			} else {
+				block, _ := pem.Decode(csr.Spec.Request)
+				req, err := x509.ParseCertificateRequest(block.Bytes)
+				if !assert.Check(t, err) {
+					continue
+				}
+				der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+					SerialNumber: big.NewInt(time.Now().UnixNano()),
+					Subject:      req.Subject,
+					DNSNames:     req.DNSNames,
+					IPAddresses:  req.IPAddresses,
+					NotBefore:    time.Now(),
+					NotAfter:     time.Now().Add(validity),
+				}, ca, req.PublicKey, caKey)
+				if !assert.Check(t, err) {
+					continue
+				}
+				csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			}
+			_, err := csrs.UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+			assert.Check(t, err)
+		}
+	}
+}
+
+func TestCertificateRequester(t *testing.T) {
+	defer func(d time.Duration) { csrPollInterval = d }(csrPollInterval)
+	csrPollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	csrs := fake.NewSimpleClientset().CertificatesV1().CertificateSigningRequests()
+	signed := make(chan struct{})
+	go func() {
+		defer close(signed)
+		signCSRs(ctx, t, csrs, false, time.Hour)
+	}()
+	defer func() {
+		cancel()
+		<-signed
+	}()
+
+	r := newCertificateRequester(csrs, "node", []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+		{Type: corev1.NodeHostName, Address: "node.example.com"},
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+		{Type: corev1.NodeExternalIP, Address: ""},
+	})
+	cert, name, err := r.request(ctx)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(cert.Leaf.Subject.CommonName, "system:node:node"))
+	assert.Check(t, is.DeepEqual(cert.Leaf.DNSNames, []string{"node.example.com"}))
+	assert.Check(t, is.Len(cert.Leaf.IPAddresses, 1))
+	assert.Check(t, cert.Leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")))
+
+	list, err := csrs.List(ctx, metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(list.Items, 1))
+	assert.Check(t, is.Equal(list.Items[0].Name, name))
+	spec := list.Items[0].Spec
+	assert.Check(t, is.Equal(spec.SignerName, certificatesv1.KubeletServingSignerName))
+	assert.Check(t, is.DeepEqual(spec.Usages, []certificatesv1.KeyUsage{
+		certificatesv1.UsageDigitalSignature, certificatesv1.UsageKeyEncipherment, certificatesv1.UsageServerAuth,
+	}))
+
+	// The certificate is served once issued
+	s, err := newServingCerts(&tls.Config{}, "", "", "")
+	assert.NilError(t, err)
+	_, err = s.config().GetCertificate(&tls.ClientHelloInfo{})
+	assert.Check(t, is.ErrorContains(err, "no serving certificate"))
+
+	go r.run(ctx, s)
+	select {
+	case <-r.Issued():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the certificate to be issued")
+	}
+	cert, err = s.config().GetCertificate(&tls.ClientHelloInfo{})
+	assert.NilError(t, err)
+	assert.Check(t, cert != nil)
+}
+
+func TestCertificateRequesterDeletesPrevious(t *testing.T) {
+	defer func(d time.Duration) { csrPollInterval = d }(csrPollInterval)
+	csrPollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := fake.NewSimpleClientset()
+	var (
+		mu      sync.Mutex
+		created []string
+	)
+	client.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		created = append(created, action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest).Name)
+		mu.Unlock()
+		return false, nil, nil
+	})
+	csrs := client.CertificatesV1().CertificateSigningRequests()
+	signed := make(chan struct{})
+	go func() {
+		defer close(signed)
+		// Rotated every few hundred milliseconds
+		signCSRs(ctx, t, csrs, false, 500*time.Millisecond)
+	}()
+	defer func() {
+		cancel()
+		<-signed
+	}()
+
+	r := newCertificateRequester(csrs, "node", []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}})
+	s, err := newServingCerts(&tls.Config{}, "", "", "")
+	assert.NilError(t, err)
+	go r.run(ctx, s)
+
+	select {
+	case <-r.Issued():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the certificate to be issued")
+	}
+	mu.Lock()
+	first := created[0]
+	mu.Unlock()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		_, err := csrs.Get(ctx, first, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the previous certificate signing request to be deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The current request and at most a pending one are left
+	list, err := csrs.List(ctx, metav1.ListOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, len(list.Items) <= 2, "%d certificate signing requests left", len(list.Items))
+}
+
+func TestCertificateRequesterDenied(t *testing.T) {
+	defer func(d time.Duration) { csrPollInterval = d }(csrPollInterval)
+	csrPollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	csrs := fake.NewSimpleClientset().CertificatesV1().CertificateSigningRequests()
+	signed := make(chan struct{})
+	go func() {
+		defer close(signed)
+		signCSRs(ctx, t, csrs, true, time.Hour)
+	}()
+	defer func() {
+		cancel()
+		<-signed
+	}()
+
+	r := newCertificateRequester(csrs, "node", []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}})
+	_, name, err := r.request(ctx)
+	assert.Check(t, is.ErrorContains(err, "NotAllowed: go away"))
+	assert.Check(t, name != "")
+
+	r = newCertificateRequester(csrs, "node", nil)
+	_, name, err = r.request(ctx)
+	assert.Check(t, is.ErrorContains(err, "node node has no addresses"))
+	assert.Check(t, is.Equal(name, ""))
+}
+
+func TestRotationDeadline(t *testing.T) {
+	now := time.Now()
+	cert := &x509.Certificate{NotBefore: now, NotAfter: now.Add(100 * time.Hour)}
+	for i := 0; i < 100; i++ {
+		d := rotationDeadline(cert)
+		assert.Check(t, !d.Before(now.Add(70*time.Hour)), d)
+		assert.Check(t, !d.After(now.Add(90*time.Hour)), d)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// envAnnotation is the flag annotation holding the name of the environment
+// variable which sets the flag.
+const envAnnotation = "virtual-kubelet.io/env"
+
+// envVar returns the name of the environment variable for a flag, which is
+// the flag name in upper case with a `VK_` prefix, e.g. `--node-labels` is
+// set by `VK_NODE_LABELS`.
+func envVar(flag string) string {
+	return "VK_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flag))
+}
+
+// envAlias is another environment variable which sets the same flag.
+type envAlias struct {
+	name string
+	// deprecated aliases log a warning when used.
+	deprecated bool
+}
+
+// envAliases maps flag names to the environment variables which were used
+// before every flag got its own `VK_` variable.
+// An alias is only used if the `VK_` variable is not set; empty values are
+// ignored.
+var envAliases = map[string][]envAlias{
+	"kubeconfig":           {{name: "KUBECONFIG"}},
+	"nodename":             {{name: "DEFAULTNODE_NAME", deprecated: true}},
+	"port":                 {{name: "KUBELET_PORT", deprecated: true}},
+	"taint":                {{name: "VKUBELET_TAINT_KEY", deprecated: true}},
+	"taint-value":          {{name: "VKUBELET_TAINT_VALUE", deprecated: true}},
+	"taint-effect":         {{name: "VKUBELET_TAINT_EFFECT", deprecated: true}},
+	"client-verify-ca":     {{name: "APISERVER_CA_CERT_LOCATION", deprecated: true}},
+	"tls-cert-file":        {{name: "APISERVER_CERT_LOCATION", deprecated: true}},
+	"tls-private-key-file": {{name: "APISERVER_KEY_LOCATION", deprecated: true}},
+	"master-uri":           {{name: "MASTER_URI", deprecated: true}},
+}
+
+// bindEnv marks every flag in flags to be settable from its environment
+// variable, see `applyEnv`.
+func bindEnv(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		flags.SetAnnotation(f.Name, envAnnotation, []string{envVar(f.Name)}) //nolint:errcheck
+	})
+}
+
+// applyEnv sets every flag bound with `bindEnv` which was not set on the
+// command line from its environment variable (or one of its aliases).
+// It returns the name of the environment variable used for every flag set.
+//
+// The flags are not marked as changed.
+func applyEnv(ctx context.Context, flags *pflag.FlagSet) (map[string]string, error) {
+	var err error
+	fromEnv := make(map[string]string)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || len(f.Annotations[envAnnotation]) == 0 {
+			return
+		}
+
+		name := f.Annotations[envAnnotation][0]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			for _, alias := range envAliases[f.Name] {
+				if value = os.Getenv(alias.name); value == "" {
+					continue
+				}
+				if alias.deprecated {
+					log.G(ctx).WithField("env", alias.name).Warnf("Environment variable %s is deprecated, use %s instead", alias.name, name)
+				}
+				name, ok = alias.name, true
+				break
+			}
+		}
+		if !ok {
+			return
+		}
+
+		if e := f.Value.Set(value); e != nil {
+			err = errdefs.AsInvalidInput(errors.Wrapf(e, "invalid value %q for environment variable %s", value, name))
+			return
+		}
+		fromEnv[f.Name] = name
+	})
+	return fromEnv, err
+}
@@ -0,0 +1,89 @@
+package root
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	assert.NilError(t, os.Setenv(key, value))
+	t.Cleanup(func() { os.Unsetenv(key) })
+}
+
+func TestEnvVar(t *testing.T) {
+	assert.Check(t, is.Equal(envVar("nodename"), "VK_NODENAME"))
+	assert.Check(t, is.Equal(envVar("node-labels"), "VK_NODE_LABELS"))
+	assert.Check(t, is.Equal(envVar("klog.v"), "VK_KLOG_V"))
+}
+
+func TestApplyEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-env")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.yaml")
+	assert.NilError(t, ioutil.WriteFile(configPath, []byte("podSyncWorkers: 3\nkubeClusterDomain: from-file\nlistenPort: 1234\n"), 0600))
+
+	setEnv(t, "VK_CONFIG", configPath)
+	setEnv(t, "VK_NODENAME", "from-env")
+	setEnv(t, "DEFAULTNODE_NAME", "from-legacy-env")
+	setEnv(t, "KUBELET_PORT", "4321")
+	setEnv(t, "VK_POD_SYNC_WORKERS", "5")
+	setEnv(t, "VK_KUBE_API_QPS", "7")
+	setEnv(t, "VK_NODE_LABELS", "a=1,b=2")
+	setEnv(t, "VK_SYNC_PODS_RATE_LIMITER", "bucket:1qps/1")
+
+	o := opts.New()
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	assert.NilError(t, flags.Parse([]string{"--kube-api-qps", "9"}))
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+
+	assert.Check(t, is.Equal(o.ConfigPath, configPath))
+	// VK_ variables win over the legacy ones
+	assert.Check(t, is.Equal(o.NodeName, "from-env"))
+	// legacy variables win over the file
+	assert.Check(t, is.Equal(o.ListenPort, int32(4321)))
+	// env wins over the file
+	assert.Check(t, is.Equal(o.PodSyncWorkers, 5))
+	assert.Check(t, is.Equal(o.KubeClusterDomain, "from-file"))
+	// flags win over env
+	assert.Check(t, is.Equal(o.KubeAPIQPS, int32(9)))
+	assert.Check(t, is.DeepEqual(o.NodeLabels, []string{"a=1", "b=2"}))
+	assert.Check(t, is.Equal(opts.RateLimiterSpec(o.SyncPodsFromKubernetesRateLimiter), "bucket:1qps/1"))
+
+	t.Run("invalid value", func(t *testing.T) {
+		setEnv(t, "VK_POD_SYNC_WORKERS", "many")
+
+		o := opts.New()
+		flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+		installFlags(flags, o)
+		err := resolveOpts(context.Background(), flags, o)
+		assert.ErrorContains(t, err, "VK_POD_SYNC_WORKERS")
+	})
+}
+
+// TestEveryFlagHasEnv makes sure flags added to installFlags get an
+// environment variable.
+func TestEveryFlagHasEnv(t *testing.T) {
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, opts.New())
+	flags.VisitAll(func(f *pflag.Flag) {
+		if _, ok := f.Value.(aliasValue); ok {
+			// Set by the variable of the flag they are an alias of
+			return
+		}
+		assert.Check(t, is.DeepEqual(f.Annotations[envAnnotation], []string{envVar(f.Name)}), f.Name)
+	})
+	for name := range envAliases {
+		assert.Check(t, flags.Lookup(name) != nil, "alias for unknown flag %s", name)
+	}
+}
@@ -20,35 +20,112 @@ import (
 
 	"github.com/spf13/pflag"
 	"github.com/virtual-kubelet/node-cli/opts"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
 
-func installFlags(flags *pflag.FlagSet, c *opts.Opts) {
+// installFlags adds all the flags for the options in c to fs.
+// Every flag can also be set with an environment variable, see `envVar`.
+func installFlags(fs *pflag.FlagSet, c *opts.Opts) {
+	flags := pflag.NewFlagSet("virtual-kubelet", pflag.ContinueOnError)
+
+	flags.StringVar(&c.ConfigPath, "config", c.ConfigPath, "config file (YAML or JSON) to load options from, flags and environment variables take precedence over values in the file")
+	flags.StringVar(&c.KubeletConfigPath, "kubelet-config", c.KubeletConfigPath, "KubeletConfiguration file (kubelet.config.k8s.io/v1beta1) to load the supported options from, --config takes precedence over values in this file")
 	flags.StringVar(&c.KubeConfigPath, "kubeconfig", c.KubeConfigPath, "kube config file to use for connecting to the Kubernetes API server")
 	flags.StringVar(&c.KubeNamespace, "namespace", c.KubeNamespace, "kubernetes namespace (default is 'all')")
+	flags.StringSliceVar(&c.Namespaces, "namespaces", c.Namespaces,
+		"kubernetes namespaces to watch, default is all; cannot be used with --namespace (may be repeated or comma separated)")
+	flags.StringSliceVar(&c.ExcludeNamespaces, "exclude-namespaces", c.ExcludeNamespaces,
+		"kubernetes namespaces not to watch, e.g. kube-system (may be repeated or comma separated)")
 	flags.StringVar(&c.KubeClusterDomain, "cluster-domain", c.KubeClusterDomain, "kubernetes cluster-domain (default is 'cluster.local')")
 	flags.StringVar(&c.NodeName, "nodename", c.NodeName, "kubernetes node name")
 	flags.StringVar(&c.OperatingSystem, "os", c.OperatingSystem, "Operating System (Linux/Windows)")
+	flags.StringVar(&c.NodeArch, "node-arch", c.NodeArch, "CPU architecture the node reports, e.g. arm64 (default is the provider's value, then the architecture virtual-kubelet runs on)")
 	flags.StringVar(&c.Provider, "provider", c.Provider, "cloud provider")
 	flags.StringVar(&c.ProviderConfigPath, "provider-config", c.ProviderConfigPath, "cloud provider configuration file")
 	flags.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "address to listen for metrics/stats requests")
+	flags.StringVar(&c.HealthAddr, "health-addr", c.HealthAddr,
+		"address to serve the unauthenticated /healthz (liveness) and /readyz (readiness) checks on, e.g. :10248 (default is not to serve them)")
+	flags.Int32Var(&c.ListenPort, "port", c.ListenPort, "port to serve the kubelet API on")
+	flags.StringSliceVar(&c.ListenAddresses, "address", c.ListenAddresses,
+		"IP addresses (or ip:port pairs) to serve the kubelet API on, default is all interfaces; use e.g. 0.0.0.0,:: for dual-stack (may be repeated or comma separated)")
+	flags.StringVar(&c.TLSCertFile, "tls-cert-file", c.TLSCertFile,
+		"certificate to serve the kubelet API with, the file is checked for changes every "+certPollInterval.String())
+	flags.StringVar(&c.TLSPrivateKeyFile, "tls-private-key-file", c.TLSPrivateKeyFile, "private key matching --tls-cert-file")
+	flags.BoolVar(&c.ServerTLSBootstrap, "rotate-server-certificates", c.ServerTLSBootstrap,
+		"request the serving certificate from the kubernetes.io/kubelet-serving signer through the certificates API, and renew it before it expires; --tls-cert-file is served until the first one is issued")
+	flags.StringVar(&c.TLSMode, "tls-mode", c.TLSMode,
+		"what to do without a serving certificate or client CA: fail startup (require), serve with a self-signed certificate for the node addresses (self-signed), or do not serve the kubelet API (disabled)")
+	flags.StringVar(&c.TLSMinVersion, "tls-min-version", c.TLSMinVersion, "minimum TLS version of the TLS listeners: VersionTLS12 or VersionTLS13")
+	flags.StringSliceVar(&c.TLSCipherSuites, "tls-cipher-suites", c.TLSCipherSuites,
+		"cipher suites the TLS listeners allow, by their IANA or Go names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; insecure ones are rejected (default is a list without known weak cipher suites)")
+	flags.StringVar(&c.MasterURI, "master-uri", c.MasterURI, "address of the Kubernetes API server, overrides the one in the kube config")
 
 	flags.StringVar(&c.TaintKey, "taint", c.TaintKey, "Set node taint key")
+	flags.StringVar(&c.TaintValue, "taint-value", c.TaintValue, "node taint value (default is the provider name)")
+	flags.StringVar(&c.TaintEffect, "taint-effect", c.TaintEffect, "node taint effect")
 	flags.BoolVar(&c.DisableTaint, "disable-taint", c.DisableTaint, "disable the virtual-kubelet node taint")
-	flags.MarkDeprecated("taint", "Taint key should now be configured using the VK_TAINT_KEY environment variable")
+	flags.StringSliceVar(&c.RegisterWithTaints, "register-with-taints", c.RegisterWithTaints,
+		"extra taints to register the node with, in the form key[=value]:effect (may be repeated or comma separated, changes require a restart)")
+	flags.StringSliceVar(&c.NodeLabels, "node-labels", c.NodeLabels,
+		"extra labels to register the node with, in the form key=value (may be repeated or comma separated, changes require a restart)")
+	flags.StringSliceVar(&c.NodeAnnotations, "node-annotations", c.NodeAnnotations,
+		"extra annotations to register the node with, in the form key=value (may be repeated or comma separated)")
+	flags.StringSliceVar(&c.AllowedNodeLabels, "allowed-node-labels", c.AllowedNodeLabels,
+		"patterns of node label keys to keep even if denied, e.g. node.example.com/* (may be repeated or comma separated)")
+	flags.StringSliceVar(&c.DeniedNodeLabels, "denied-node-labels", c.DeniedNodeLabels,
+		"patterns of node label keys the node must not be registered with, e.g. node-role.kubernetes.io/* (may be repeated or comma separated)")
+	flags.StringSliceVar(&c.AllowedNodeTaints, "allowed-node-taints", c.AllowedNodeTaints,
+		"patterns of node taint keys to keep even if denied (may be repeated or comma separated)")
+	flags.StringSliceVar(&c.DeniedNodeTaints, "denied-node-taints", c.DeniedNodeTaints,
+		"patterns of node taint keys the node must not be registered with (may be repeated or comma separated)")
+	flags.BoolVar(&c.NodeRestriction, "node-restriction", c.NodeRestriction,
+		"deny the node labels the NodeRestriction admission plugin forbids a kubelet to set")
+	flags.BoolVar(&c.StrictNodePolicy, "strict-node-policy", c.StrictNodePolicy,
+		"fail startup if the node has denied labels or taints, instead of registering it without them")
+	flags.StringSliceVar(&c.SystemReserved, "system-reserved", c.SystemReserved,
+		"resources to subtract from the allocatable resources reported by the provider, in the form name=quantity, e.g. cpu=500m,memory=1Gi (may be repeated or comma separated)")
+	flags.StringSliceVar(&c.ExtendedResources, "extended-resource", c.ExtendedResources,
+		"extended resources to add to the capacity of the node, in the form name=quantity, e.g. example.com/license=10; pods requesting more than is free are rejected (may be repeated or comma separated)")
+	flags.MarkDeprecated("taint", "Taint key should now be configured using the VK_TAINT environment variable")
 
 	flags.IntVar(&c.PodSyncWorkers, "pod-sync-workers", c.PodSyncWorkers, `set the number of pod synchronization workers`)
 	flags.BoolVar(&c.EnableNodeLease, "enable-node-lease", c.EnableNodeLease, `use node leases (1.13) for node heartbeats`)
+	flags.BoolVar(&c.ForceTakeover, "force-takeover", c.ForceTakeover, "start even if another virtual-kubelet instance holds the node lease")
+
+	flags.BoolVar(&c.LeaderElect, "leader-elect", c.LeaderElect,
+		"run several instances for the same node, only the elected leader runs the pod and node controllers while the others stand by")
+	flags.DurationVar(&c.LeaderElectLeaseDuration, "leader-elect-lease-duration", c.LeaderElectLeaseDuration,
+		"how long standby instances wait before taking over from a leader which stopped renewing its lease")
+	flags.DurationVar(&c.LeaderElectRenewDeadline, "leader-elect-renew-deadline", c.LeaderElectRenewDeadline,
+		"how long the leader keeps trying to renew its lease before stepping down, must be less than the lease duration")
+	flags.DurationVar(&c.LeaderElectRetryPeriod, "leader-elect-retry-period", c.LeaderElectRetryPeriod,
+		"how often instances try to acquire or renew the leader election lease")
+	flags.StringVar(&c.LeaderElectResourceNamespace, "leader-elect-resource-namespace", c.LeaderElectResourceNamespace,
+		"namespace of the leader election lease")
+	flags.StringVar(&c.LeaderElectResourceName, "leader-elect-resource-name", c.LeaderElectResourceName,
+		"name of the leader election lease (default is virtual-kubelet-<nodename>)")
 
 	flags.DurationVar(&c.InformerResyncPeriod, "full-resync-period", c.InformerResyncPeriod, "how often to perform a full resync of pods between kubernetes and the provider")
 	flags.DurationVar(&c.StartupTimeout, "startup-timeout", c.StartupTimeout, "How long to wait for the virtual-kubelet to start")
+	flags.StringVar(&c.OnShutdown, "on-shutdown", c.OnShutdown,
+		"what to do with the node on shutdown: leave it as is, delete it and its lease, or cordon it (unschedulable with a NoExecute taint)")
+	flags.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long the --on-shutdown action may take")
 
 	flags.Int32Var(&c.KubeAPIQPS, "kube-api-qps", c.KubeAPIQPS,
 		"kubeAPIQPS is the QPS to use while talking with kubernetes apiserver")
 	flags.Int32Var(&c.KubeAPIBurst, "kube-api-burst", c.KubeAPIBurst,
 		"kubeAPIBurst is the burst to allow while talking with kubernetes apiserver")
 
-	flags.StringVar(&c.ClientCACert, "client-verify-ca", os.Getenv("APISERVER_CA_CERT_LOCATION"), "CA cert to use to verify client requests")
+	flags.Var(rateLimiterValue{&c.SyncPodsFromKubernetesRateLimiter}, "sync-pods-rate-limiter",
+		"rate limiter for the queue of pods to sync from kubernetes to the provider, e.g. "+opts.DefaultRateLimiterSpec)
+	flags.Var(rateLimiterValue{&c.DeletePodsFromKubernetesRateLimiter}, "delete-pods-rate-limiter",
+		"rate limiter for the queue of pods to delete from kubernetes, e.g. "+opts.DefaultRateLimiterSpec)
+	flags.Var(rateLimiterValue{&c.SyncPodStatusFromProviderRateLimiter}, "sync-pod-status-rate-limiter",
+		"rate limiter for the queue of pod status updates from the provider, e.g. "+opts.DefaultRateLimiterSpec)
+
+	flags.StringVar(&c.ClientCACert, "client-verify-ca", c.ClientCACert,
+		"CA cert to use to verify client requests, the file is checked for changes every "+certPollInterval.String())
 	flags.BoolVar(&c.AllowUnauthenticatedClients, "no-verify-clients", c.AllowUnauthenticatedClients, "Do not require client certificate validation")
 
 	flags.BoolVar(&c.Authentication.Webhook.Enabled, "authentication-token-webhook", c.Authentication.Webhook.Enabled, ""+
@@ -67,6 +144,66 @@ func installFlags(flags *pflag.FlagSet, c *opts.Opts) {
 		f.Name = "klog." + f.Name
 		flags.AddGoFlag(f)
 	})
+
+	bindEnv(flags)
+	// Aliases are added once the environment variables are bound, they
+	// are only set by the variable of the flag they are an alias of.
+	addFlagAlias(flags, "listen-addr", "address")
+	addFlagAlias(flags, "reserved", "system-reserved")
+	fs.AddFlagSet(flags)
+}
+
+// addFlagAlias adds the flag alias, which sets the flag name.
+// Both flags share the same value, so they can be mixed, and the flag is
+// marked as changed when the alias is set.
+func addFlagAlias(flags *pflag.FlagSet, alias, name string) {
+	f := flags.VarPF(aliasValue{flags: flags, name: name}, alias, "", "alias for --"+name)
+	// The default is shown for the flag only.
+	f.DefValue = ""
+}
+
+// aliasValue is the flag value of an alias, see `addFlagAlias`.
+type aliasValue struct {
+	flags *pflag.FlagSet
+	name  string
+}
+
+func (v aliasValue) String() string {
+	return v.flags.Lookup(v.name).Value.String()
+}
+
+func (v aliasValue) Set(s string) error {
+	return v.flags.Set(v.name, s)
+}
+
+func (v aliasValue) Type() string {
+	return v.flags.Lookup(v.name).Value.Type()
+}
+
+// rateLimiterValue is a flag value for a workqueue rate limiter, see
+// `opts.ParseRateLimiter` for the syntax.
+type rateLimiterValue struct {
+	l *workqueue.RateLimiter
+}
+
+func (v rateLimiterValue) String() string {
+	if v.l == nil || *v.l == nil {
+		return ""
+	}
+	return opts.RateLimiterSpec(*v.l)
+}
+
+func (v rateLimiterValue) Set(s string) error {
+	l, err := opts.ParseRateLimiter(s)
+	if err != nil {
+		return err
+	}
+	*v.l = l
+	return nil
+}
+
+func (v rateLimiterValue) Type() string {
+	return "rateLimiter"
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,197 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/tools/cache"
+)
+
+// controller is what the health checks need of the pod and node
+// controllers.
+type controller interface {
+	Ready() <-chan struct{}
+	Done() <-chan struct{}
+	Err() error
+}
+
+// nodeHealth is the state of the controllers of a node run by this
+// instance, which the health checks report.
+type nodeHealth struct {
+	// ctx is cancelled when the node stops running, the controllers are
+	// expected to stop then.
+	ctx  context.Context
+	pods controller
+	// nodes is set once the node is registered.
+	nodes controller
+}
+
+// namedSynced is an informer the readiness checks wait for.
+type namedSynced struct {
+	name   string
+	synced cache.InformerSynced
+}
+
+// healthChecks returns the liveness and readiness checks of the process.
+//
+// Liveness checks that the pod and node controllers of every node did not
+// stop. Readiness checks that the informers are synced, that the pod
+// controller of every node is ready and the node registered, and that the
+// kubelet API of every node has a serving certificate.
+// Nodes not run by this instance, e.g. while it is on standby with leader
+// election, pass the node checks.
+func healthChecks(nodes []*virtualNode, informers []namedSynced) (livez, readyz []healthz.HealthChecker) {
+	livez = []healthz.HealthChecker{
+		healthz.PingHealthz,
+		nodeCheck("pod-controller", nodes, func(h nodeHealth) error {
+			return checkStopped(h.ctx, h.pods, "pod controller")
+		}),
+		nodeCheck("node-controller", nodes, func(h nodeHealth) error {
+			if h.nodes == nil {
+				return nil
+			}
+			return checkStopped(h.ctx, h.nodes, "node controller")
+		}),
+	}
+	readyz = []healthz.HealthChecker{
+		healthz.PingHealthz,
+		healthz.NamedCheck("informer-sync", func(*http.Request) error {
+			var unsynced []string
+			for _, i := range informers {
+				if !i.synced() {
+					unsynced = append(unsynced, i.name)
+				}
+			}
+			if len(unsynced) > 0 {
+				return errors.Errorf("informers not synced: %s", strings.Join(unsynced, ", "))
+			}
+			return nil
+		}),
+		nodeCheck("pod-controller", nodes, func(h nodeHealth) error {
+			return checkReady(h.pods, "pod controller not ready")
+		}),
+		nodeCheck("node-registration", nodes, func(h nodeHealth) error {
+			if h.nodes == nil {
+				return errors.New("node not registered")
+			}
+			return checkReady(h.nodes, "node not registered")
+		}),
+		healthz.NamedCheck("serving-certificate", func(*http.Request) error {
+			var errs []error
+			for _, n := range nodes {
+				if n.certRequester == nil {
+					continue
+				}
+				select {
+				case <-n.certRequester.Issued():
+				default:
+					errs = append(errs, errors.Errorf("node %s: no serving certificate issued yet", n.spec.Name))
+				}
+			}
+			return utilerrors.NewAggregate(errs)
+		}),
+	}
+	return livez, readyz
+}
+
+// nodeCheck returns a health check running check on the nodes run by this
+// instance.
+func nodeCheck(name string, nodes []*virtualNode, check func(nodeHealth) error) healthz.HealthChecker {
+	return healthz.NamedCheck(name, func(*http.Request) error {
+		var errs []error
+		for _, n := range nodes {
+			h, ok := n.health()
+			if !ok {
+				continue
+			}
+			if err := check(h); err != nil {
+				errs = append(errs, errors.Wrapf(err, "node %s", n.spec.Name))
+			}
+		}
+		return utilerrors.NewAggregate(errs)
+	})
+}
+
+// checkStopped returns an error if c stopped before ctx was cancelled.
+func checkStopped(ctx context.Context, c controller, what string) error {
+	select {
+	case <-c.Done():
+	default:
+		return nil
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := c.Err(); err != nil {
+		return errors.Wrapf(err, "%s stopped", what)
+	}
+	return errors.Errorf("%s stopped", what)
+}
+
+func checkReady(c controller, msg string) error {
+	select {
+	case <-c.Ready():
+		return nil
+	default:
+		return errors.New(msg)
+	}
+}
+
+// informerSyncChecks returns the informers of the process.
+func informerSyncChecks(shared *sharedResources, nodes []*virtualNode) []namedSynced {
+	informers := []namedSynced{
+		{"secrets", shared.secrets.Informer().HasSynced},
+		{"configmaps", shared.configMaps.Informer().HasSynced},
+		{"services", shared.services.Informer().HasSynced},
+		{"persistentvolumeclaims", shared.pvcs.Informer().HasSynced},
+		{"persistentvolumes", shared.pvs.Informer().HasSynced},
+	}
+	for _, n := range nodes {
+		informers = append(informers, namedSynced{"pods/" + n.spec.Name, n.podInformer.Informer().HasSynced})
+	}
+	return informers
+}
+
+// newHealthHandler serves the liveness checks on `/healthz` and the
+// readiness checks on `/readyz`, each check is also served on its own path,
+// e.g. `/readyz/informer-sync`.
+// Add `?verbose` to list the result of every check.
+func newHealthHandler(livez, readyz []healthz.HealthChecker) http.Handler {
+	mux := http.NewServeMux()
+	healthz.InstallPathHandler(mux, "/healthz", livez...)
+	healthz.InstallPathHandler(mux, "/readyz", readyz...)
+	return mux
+}
+
+// setupHealthServer starts the unauthenticated health server on addr, it
+// is stopped by closing the returned server.
+func setupHealthServer(ctx context.Context, addr string, livez, readyz []healthz.HealthChecker) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not setup listener for health http server")
+	}
+	s := &http.Server{
+		Handler: newHealthHandler(livez, readyz),
+	}
+	go serveHTTP(ctx, s, l, "health")
+	return s, nil
+}
@@ -0,0 +1,93 @@
+package root
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+type fakeController struct {
+	ready, done chan struct{}
+	err         error
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{ready: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (c *fakeController) Ready() <-chan struct{} { return c.ready }
+func (c *fakeController) Done() <-chan struct{}  { return c.done }
+func (c *fakeController) Err() error             { return c.err }
+
+func TestHealthChecks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	running := &virtualNode{spec: opts.NodeSpec{Name: "running"}}
+	standby := &virtualNode{spec: opts.NodeSpec{Name: "standby"}}
+	bootstrapping := &virtualNode{spec: opts.NodeSpec{Name: "bootstrapping"}, certRequester: newCertificateRequester(nil, "bootstrapping", nil)}
+	pods := newFakeController()
+	running.runState = &nodeHealth{ctx: ctx, pods: pods}
+	synced := false
+	livez, readyz := healthChecks([]*virtualNode{running, standby, bootstrapping}, []namedSynced{
+		{"pods/running", func() bool { return synced }},
+	})
+	h := newHealthHandler(livez, readyz)
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := get("/healthz?verbose")
+	assert.Check(t, is.Equal(code, http.StatusOK), body)
+	assert.Check(t, is.Contains(body, "[+]pod-controller ok"))
+	assert.Check(t, is.Contains(body, "[+]node-controller ok"))
+
+	code, body = get("/readyz")
+	assert.Check(t, is.Equal(code, http.StatusInternalServerError))
+	assert.Check(t, is.Contains(body, "[-]informer-sync failed"))
+	assert.Check(t, is.Contains(body, "[-]pod-controller failed"))
+	assert.Check(t, is.Contains(body, "[-]node-registration failed"))
+	assert.Check(t, is.Contains(body, "[-]serving-certificate failed"))
+	_, body = get("/readyz/informer-sync")
+	assert.Check(t, is.Contains(body, "informers not synced: pods/running"))
+	_, body = get("/readyz/node-registration")
+	assert.Check(t, is.Contains(body, "node running: node not registered"))
+	_, body = get("/readyz/serving-certificate")
+	assert.Check(t, is.Contains(body, "node bootstrapping: no serving certificate issued yet"))
+
+	// Ready once the informers are synced, the node registered and the
+	// serving certificate issued
+	synced = true
+	close(bootstrapping.certRequester.issued)
+	close(pods.ready)
+	nodes := newFakeController()
+	close(nodes.ready)
+	running.runState.nodes = nodes
+	code, body = get("/readyz?verbose")
+	assert.Check(t, is.Equal(code, http.StatusOK), body)
+	assert.Check(t, is.Contains(body, "[+]node-registration ok"))
+
+	// A controller stopping while the node runs is not live
+	nodes.err = errors.New("boom")
+	close(nodes.done)
+	code, body = get("/healthz")
+	assert.Check(t, is.Equal(code, http.StatusInternalServerError))
+	assert.Check(t, is.Contains(body, "[+]pod-controller ok"))
+	assert.Check(t, is.Contains(body, "[-]node-controller failed"))
+	_, body = get("/healthz/node-controller")
+	assert.Check(t, is.Contains(body, "node running: node controller stopped: boom"))
+
+	// ... but is expected once it stops running
+	cancel()
+	code, body = get("/healthz")
+	assert.Check(t, is.Equal(code, http.StatusOK), body)
+}
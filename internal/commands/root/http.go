@@ -17,13 +17,10 @@ package root
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -31,6 +28,7 @@ import (
 	"github.com/virtual-kubelet/node-cli/provider"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // AcceptedCiphers is the list of accepted TLS ciphers, with known weak ciphers elided
@@ -47,46 +45,53 @@ var AcceptedCiphers = []uint16{
 	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 }
 
-func loadTLSConfig(ctx context.Context, certPath, keyPath, caPath string, allowUnauthenticatedClients, authWebhookEnabled bool) (*tls.Config, error) {
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "error loading tls certs")
-	}
-
-	var (
-		caPool     *x509.CertPool
-		clientAuth = tls.RequireAndVerifyClientCert
-	)
+// loadTLSConfig loads the serving certificate and client CA, see
+// `servingCerts` for how they are reloaded.
+func loadTLSConfig(ctx context.Context, cfg *apiServerConfig) (*servingCerts, error) {
+	clientAuth := tls.RequireAndVerifyClientCert
 
-	if allowUnauthenticatedClients {
+	if cfg.AllowUnauthenticatedClients {
 		clientAuth = tls.NoClientCert
 	}
-	if authWebhookEnabled {
+	if cfg.AuthWebhookEnabled {
 		clientAuth = tls.RequestClientCert
 	}
 
-	if caPath != "" {
-		caPool = x509.NewCertPool()
-		pem, err := ioutil.ReadFile(caPath)
-		if err != nil {
-			return nil, err
-		}
-		if !caPool.AppendCertsFromPEM(pem) {
-			return nil, errors.New("error appending ca cert to certificate pool")
-		}
-	}
+	base := baseTLSConfig(cfg)
+	base.ClientAuth = clientAuth
+	return newServingCerts(base, cfg.CertPath, cfg.KeyPath, cfg.CACertPath)
+}
 
+// baseTLSConfig returns the TLS version and cipher suites every TLS listener
+// is configured with.
+func baseTLSConfig(cfg *apiServerConfig) *tls.Config {
+	minVersion := cfg.TLSMinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	cipherSuites := cfg.TLSCipherSuites
+	if len(cipherSuites) == 0 {
+		cipherSuites = AcceptedCiphers
+	}
 	return &tls.Config{
-		Certificates:             []tls.Certificate{cert},
-		MinVersion:               tls.VersionTLS12,
+		MinVersion:               minVersion,
 		PreferServerCipherSuites: true,
-		CipherSuites:             AcceptedCiphers,
-		ClientCAs:                caPool,
-		ClientAuth:               clientAuth,
-	}, nil
+		CipherSuites:             cipherSuites,
+	}
 }
 
-func setupHTTPServer(ctx context.Context, p provider.Provider, cfg *apiServerConfig) (_ func(), retErr error) {
+// closerFunc adapts a function to io.Closer.
+type closerFunc func()
+
+func (f closerFunc) Close() error {
+	f()
+	return nil
+}
+
+// setupHTTPServer starts the kubelet API and metrics servers, they are
+// stopped by calling the returned function. The serving certificates of the
+// kubelet API are returned, nil when it is not served.
+func setupHTTPServer(ctx context.Context, p provider.Provider, cfg *apiServerConfig) (_ func(), _ *servingCerts, retErr error) {
 	var closers []io.Closer
 	cancel := func() {
 		for _, c := range closers {
@@ -99,20 +104,49 @@ func setupHTTPServer(ctx context.Context, p provider.Provider, cfg *apiServerCon
 		}
 	}()
 
-	if cfg.CertPath == "" || cfg.KeyPath == "" || (cfg.CACertPath == "" && !cfg.AllowUnauthenticatedClients) {
+	var served *servingCerts
+	if reason := podServerDisabledReason(cfg); reason != "" {
+		if cfg.TLSMode == opts.TLSModeRequire || cfg.TLSMode == opts.TLSModeSelfSigned {
+			return nil, nil, errors.Errorf("cannot serve the kubelet API with tls mode %s: %s", cfg.TLSMode, reason)
+		}
 		log.G(ctx).
 			WithField("certPath", cfg.CertPath).
 			WithField("keyPath", cfg.KeyPath).
 			WithField("caPath", cfg.CACertPath).
-			Error("TLS certificates not provided, not setting up pod http server")
+			Warnf("Not serving the kubelet API, so logs, exec and metrics are not available: %s (set --tls-mode to require or self-signed to change this)", reason)
 	} else {
-		tlsCfg, err := loadTLSConfig(ctx, cfg.CertPath, cfg.KeyPath, cfg.CACertPath, cfg.AllowUnauthenticatedClients, cfg.AuthWebhookEnabled)
+		certs, err := loadTLSConfig(ctx, cfg)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		l, err := tls.Listen("tcp", cfg.Addr, tlsCfg)
-		if err != nil {
-			return nil, errors.Wrapf(err, "error setting up listener for pod http server: tlsconfig: \n%+v", tlsCfg)
+		if !hasServingCert(cfg) {
+			cert, err := selfSignedCert(cfg.NodeName, cfg.NodeAddresses)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs.setCert(cert)
+			log.G(ctx).WithField("notAfter", cert.Leaf.NotAfter).Info("Serving the kubelet API with a self-signed certificate")
+		}
+		served = certs
+		tlsCfg := certs.config()
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		go certs.run(watchCtx)
+		if cfg.CertRequester != nil {
+			// Without a certificate file connections fail until the first
+			// certificate is issued, the readiness checks report it.
+			go cfg.CertRequester.run(watchCtx, certs)
+		}
+		closers = append(closers, closerFunc(stopWatch))
+		var listeners []net.Listener
+		for _, addr := range cfg.Addrs {
+			l, err := tls.Listen(listenNetwork(addr), addr, tlsCfg)
+			if err != nil {
+				for _, l := range listeners {
+					l.Close()
+				}
+				return nil, nil, errors.Wrapf(err, "error setting up listener for pod http server on %s: tlsconfig: \n%+v", addr, tlsCfg)
+			}
+			listeners = append(listeners, l)
 		}
 
 		mux := NewServeMuxWithAuth(ctx, cfg.Auth)
@@ -135,14 +169,16 @@ func setupHTTPServer(ctx context.Context, p provider.Provider, cfg *apiServerCon
 			Handler:   mux,
 			TLSConfig: tlsCfg,
 		}
-		go serveHTTP(ctx, s, l, "pods")
+		for _, l := range listeners {
+			go serveHTTP(ctx, s, l, "pods")
+		}
 		closers = append(closers, s)
 	}
 
 	if cfg.MetricsAddr != "" {
 		l, err := net.Listen("tcp", cfg.MetricsAddr)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not setup listener for pod metrics http server")
+			return nil, nil, errors.Wrap(err, "could not setup listener for pod metrics http server")
 		}
 		var summaryHandlerFunc api.PodStatsSummaryHandlerFunc
 		if mp, ok := p.(provider.PodMetricsProvider); ok {
@@ -161,7 +197,58 @@ func setupHTTPServer(ctx context.Context, p provider.Provider, cfg *apiServerCon
 		closers = append(closers, s)
 	}
 
-	return cancel, nil
+	return cancel, served, nil
+}
+
+// hasServingCert reports whether a serving certificate is configured,
+// rather than generated according to the tls mode.
+func hasServingCert(cfg *apiServerConfig) bool {
+	return cfg.CertRequester != nil || (cfg.CertPath != "" && cfg.KeyPath != "")
+}
+
+// podServerDisabledReason returns why the kubelet API cannot be served with
+// cfg, or "" if it can.
+func podServerDisabledReason(cfg *apiServerConfig) string {
+	if !hasServingCert(cfg) && cfg.TLSMode != opts.TLSModeSelfSigned {
+		return "no serving certificate, set --tls-cert-file and --tls-private-key-file or --rotate-server-certificates"
+	}
+	if cfg.CACertPath == "" && !cfg.AllowUnauthenticatedClients {
+		return "no CA to verify clients with, set --client-verify-ca or --no-verify-clients"
+	}
+	return ""
+}
+
+// daemonPort returns the port to publish in the node's daemon endpoints,
+// which is the port of the first address listened on.
+func daemonPort(addrs []string) int32 {
+	if len(addrs) == 0 {
+		return 0
+	}
+	_, port, err := net.SplitHostPort(addrs[0])
+	if err != nil {
+		return 0
+	}
+	p, _ := strconv.Atoi(port)
+	return int32(p)
+}
+
+// listenNetwork returns the network to listen on for addr.
+// IP addresses are bound to their own family, so listening on both the IPv4
+// and IPv6 wildcard addresses does not conflict.
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
 }
 
 func serveHTTP(ctx context.Context, s *http.Server, l net.Listener, name string) {
@@ -179,7 +266,7 @@ type apiServerConfig struct {
 	CACertPath                  string
 	CertPath                    string
 	KeyPath                     string
-	Addr                        string
+	Addrs                       []string
 	MetricsAddr                 string
 	StreamIdleTimeout           time.Duration
 	StreamCreationTimeout       time.Duration
@@ -187,25 +274,51 @@ type apiServerConfig struct {
 
 	Auth               AuthInterface
 	AuthWebhookEnabled bool
+
+	// CertRequester gets the serving certificate through the certificates
+	// API, the one of CertPath is served until it is issued.
+	CertRequester *certificateRequester
+	// TLSMode is what to do without a serving certificate or client CA,
+	// see `opts.Opts.TLSMode`.
+	TLSMode string
+	// TLSMinVersion and TLSCipherSuites apply to every TLS listener, the
+	// defaults are TLS 1.2 and AcceptedCiphers.
+	TLSMinVersion   uint16
+	TLSCipherSuites []uint16
+	// NodeName and NodeAddresses are what self-signed certificates are
+	// generated for.
+	NodeName      string
+	NodeAddresses []corev1.NodeAddress
 }
 
 func getAPIConfig(c *opts.Opts) (*apiServerConfig, error) {
 	config := apiServerConfig{
-		CertPath: os.Getenv("APISERVER_CERT_LOCATION"),
-		KeyPath:  os.Getenv("APISERVER_KEY_LOCATION"),
+		CertPath: c.TLSCertFile,
+		KeyPath:  c.TLSPrivateKeyFile,
 	}
 
 	config.AuthWebhookEnabled = c.Authentication.Webhook.Enabled
-	config.Addr = fmt.Sprintf(":%d", c.ListenPort)
+	addrs, err := c.ListenAddrs()
+	if err != nil {
+		return nil, err
+	}
+	config.Addrs = addrs
 	config.MetricsAddr = c.MetricsAddr
 	config.StreamIdleTimeout = c.StreamIdleTimeout
 	config.StreamCreationTimeout = c.StreamCreationTimeout
 	config.AllowUnauthenticatedClients = c.AllowUnauthenticatedClients
 
 	config.CACertPath = c.ClientCACert
-	if c.ClientCACert == "" {
-		config.CACertPath = os.Getenv("APISERVER_CA_CERT_LOCATION")
+	config.TLSMode = c.TLSMode
+	config.TLSMinVersion, err = opts.ParseTLSVersion(c.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config.TLSCipherSuites, err = opts.ParseTLSCipherSuites(c.TLSCipherSuites)
+	if err != nil {
+		return nil, err
 	}
+	config.NodeName = c.NodeName
 
 	return &config, nil
 }
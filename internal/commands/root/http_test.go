@@ -11,13 +11,17 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/opts"
 	"github.com/virtual-kubelet/node-cli/provider"
 	"github.com/virtual-kubelet/node-cli/provider/mock"
 	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/pkg/authorization/authorizer"
@@ -274,7 +278,7 @@ func TestHTTPServer(t *testing.T) {
 				assert.NilError(t, err)
 				defer closer()
 
-				c, err := net.Dial("tcp", cfg.Addr)
+				c, err := net.Dial("tcp", cfg.Addrs[0])
 				if c != nil {
 					c.Close()
 				}
@@ -295,7 +299,7 @@ func TestHTTPServer(t *testing.T) {
 				CACertPath: badPath,
 			}
 
-			_, err := setupHTTPServer(context.Background(), p, cfg)
+			_, _, err := setupHTTPServer(context.Background(), p, cfg)
 			assert.Assert(t, os.IsNotExist(errors.Cause(err)), err)
 		})
 
@@ -308,7 +312,7 @@ func TestHTTPServer(t *testing.T) {
 					}
 					defer getTestHTTPServer(t, cfg, p)()
 
-					c, err := net.Dial("tcp", cfg.Addr)
+					c, err := net.Dial("tcp", cfg.Addrs[0])
 					if c != nil {
 						c.Close()
 					}
@@ -324,11 +328,32 @@ func TestHTTPServer(t *testing.T) {
 					}
 					defer getTestHTTPServer(t, cfg, p)()
 
-					resp, err := unauthenticatedClient.Get(fmt.Sprintf("https://%s/runningpods", cfg.Addr))
+					resp, err := unauthenticatedClient.Get(fmt.Sprintf("https://%s/runningpods", cfg.Addrs[0]))
 					assert.NilError(t, err)
 					resp.Body.Close()
 					assert.Equal(t, resp.StatusCode, 200, resp.Status)
 				})
+
+				t.Run("dual-stack", func(t *testing.T) {
+					if l, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+						t.Skip("IPv6 is not available")
+					} else {
+						l.Close()
+					}
+
+					cfg := &apiServerConfig{
+						KeyPath:                     key,
+						CertPath:                    cert,
+						AllowUnauthenticatedClients: true,
+					}
+					defer getTestHTTPServer(t, cfg, p, "127.0.0.1", "::1")()
+
+					for _, addr := range cfg.Addrs {
+						c, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: clientCAPool, ServerName: "127.0.0.1"})
+						assert.NilError(t, err, addr)
+						c.Close()
+					}
+				})
 			})
 
 			t.Run("authenticated required", func(t *testing.T) {
@@ -340,11 +365,11 @@ func TestHTTPServer(t *testing.T) {
 				defer getTestHTTPServer(t, cfg, p)()
 
 				t.Run("unauthenticated client", func(t *testing.T) {
-					_, err := unauthenticatedClient.Get(fmt.Sprintf("https://%s/runningpods", cfg.Addr))
+					_, err := unauthenticatedClient.Get(fmt.Sprintf("https://%s/runningpods", cfg.Addrs[0]))
 					assert.ErrorContains(t, err, "bad certificate")
 				})
 				t.Run("authenticated client", func(t *testing.T) {
-					resp, err := authClient.Get(fmt.Sprintf("https://%s/runningpods", cfg.Addr))
+					resp, err := authClient.Get(fmt.Sprintf("https://%s/runningpods", cfg.Addrs[0]))
 					assert.NilError(t, err)
 					resp.Body.Close()
 					assert.Equal(t, resp.StatusCode, 200, resp.Status)
@@ -401,7 +426,7 @@ func TestHTTPServer(t *testing.T) {
 				assert.NilError(t, err)
 				defer closer()
 
-				resp, err := c.authClient.Get(fmt.Sprintf("https://%s/stats/summary", cfg.Addr))
+				resp, err := c.authClient.Get(fmt.Sprintf("https://%s/stats/summary", cfg.Addrs[0]))
 				assert.NilError(t, err)
 				assert.Equal(t, resp.StatusCode, c.expectedStatus, resp.Status)
 				assert.Assert(t, calledAuthenticate)
@@ -412,7 +437,77 @@ func TestHTTPServer(t *testing.T) {
 	})
 }
 
-func getTestHTTPServer(t *testing.T, cfg *apiServerConfig, p provider.Provider) func() {
+func TestHTTPServerTLSMode(t *testing.T) {
+	p, err := mock.NewProviderConfig(mock.Config{CPU: "1", Memory: "1G", Pods: "1"}, t.Name(), runtime.GOOS, "", 0)
+	assert.NilError(t, err)
+	addrs := []string{"127.0.0.1:0"}
+
+	_, _, err = setupHTTPServer(context.Background(), p, &apiServerConfig{Addrs: addrs, TLSMode: opts.TLSModeRequire})
+	assert.ErrorContains(t, err, "no serving certificate")
+	_, _, err = setupHTTPServer(context.Background(), p, &apiServerConfig{Addrs: addrs, TLSMode: opts.TLSModeSelfSigned})
+	assert.ErrorContains(t, err, "no CA to verify clients with")
+
+	closer, _, err := setupHTTPServer(context.Background(), p, &apiServerConfig{Addrs: addrs, TLSMode: opts.TLSModeDisabled})
+	assert.NilError(t, err)
+	closer()
+
+	cfg := &apiServerConfig{
+		TLSMode:                     opts.TLSModeSelfSigned,
+		AllowUnauthenticatedClients: true,
+		NodeName:                    "node",
+		NodeAddresses:               []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "127.0.0.1"}},
+	}
+	defer getTestHTTPServer(t, cfg, p)()
+
+	c, err := tls.Dial("tcp", cfg.Addrs[0], &tls.Config{InsecureSkipVerify: true})
+	assert.NilError(t, err)
+	defer c.Close()
+	cert := c.ConnectionState().PeerCertificates[0]
+	assert.Check(t, cert.VerifyHostname("127.0.0.1"))
+	assert.Check(t, cert.VerifyHostname("node"))
+}
+
+func TestHTTPServerTLSPolicy(t *testing.T) {
+	p, err := mock.NewProviderConfig(mock.Config{CPU: "1", Memory: "1G", Pods: "1"}, t.Name(), runtime.GOOS, "", 0)
+	assert.NilError(t, err)
+
+	cfg := &apiServerConfig{
+		TLSMode:                     opts.TLSModeSelfSigned,
+		AllowUnauthenticatedClients: true,
+		NodeName:                    "node",
+		TLSMinVersion:               tls.VersionTLS13,
+	}
+	closer := getTestHTTPServer(t, cfg, p)
+	_, err = tls.Dial("tcp", cfg.Addrs[0], &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	assert.Check(t, err != nil, "TLS 1.2 client accepted")
+	c, err := tls.Dial("tcp", cfg.Addrs[0], &tls.Config{InsecureSkipVerify: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(c.ConnectionState().Version, uint16(tls.VersionTLS13)))
+	c.Close()
+	closer()
+
+	cfg = &apiServerConfig{
+		TLSMode:                     opts.TLSModeSelfSigned,
+		AllowUnauthenticatedClients: true,
+		NodeName:                    "node",
+		TLSCipherSuites:             []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	}
+	defer getTestHTTPServer(t, cfg, p)()
+	_, err = tls.Dial("tcp", cfg.Addrs[0], &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+	assert.Check(t, err != nil, "cipher suite outside the allowlist accepted")
+	c, err = tls.Dial("tcp", cfg.Addrs[0], &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	assert.NilError(t, err)
+	defer c.Close()
+	assert.Check(t, is.Equal(c.ConnectionState().CipherSuite, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384))
+}
+
+// getTestHTTPServer starts the server on the first port free on all hosts,
+// hosts defaults to 127.0.0.1.
+func getTestHTTPServer(t *testing.T, cfg *apiServerConfig, p provider.Provider, hosts ...string) func() {
 	var (
 		closer func()
 		err    error
@@ -420,11 +515,18 @@ func getTestHTTPServer(t *testing.T, cfg *apiServerConfig, p provider.Provider)
 		port   = 11250
 	)
 
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+
 	for i := 0; i < 100; i++ {
-		cfg.Addr = fmt.Sprintf("127.0.0.1:%d", port+i)
-		closer, err = setupHTTPServer(ctx, p, cfg)
+		cfg.Addrs = nil
+		for _, h := range hosts {
+			cfg.Addrs = append(cfg.Addrs, net.JoinHostPort(h, strconv.Itoa(port+i)))
+		}
+		closer, _, err = setupHTTPServer(ctx, p, cfg)
 		if err == nil {
-			t.Log(cfg.Addr)
+			t.Log(cfg.Addrs[0])
 			return closer
 		}
 		if closer != nil {
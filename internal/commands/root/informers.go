@@ -0,0 +1,447 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	kubeinformers "k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// namespaceInformers are informers for the namespaced resources in a set of
+// namespaces.
+//
+// When the set lists its namespaces there is one informer factory per
+// namespace, otherwise a single factory watches all the namespaces which are
+// not excluded.
+// Either way the informers returned behave as a single informer: their
+// listers and event handlers only see objects in the set.
+type namespaceInformers struct {
+	namespaces opts.NamespaceSet
+	factories  []kubeinformers.SharedInformerFactory
+}
+
+// newNamespaceInformers creates the informer factories for namespaces.
+// selector further restricts the objects watched, it may be nil.
+func newNamespaceInformers(client kubernetes.Interface, resync time.Duration, namespaces opts.NamespaceSet, selector fields.Selector) *namespaceInformers {
+	tweak := func(sel fields.Selector) kubeinformers.SharedInformerOption {
+		return kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			if sel != nil && !sel.Empty() {
+				options.FieldSelector = sel.String()
+			}
+		})
+	}
+
+	i := &namespaceInformers{namespaces: namespaces}
+	if namespaces.None {
+		// Nothing to watch, the informers are empty.
+		return i
+	}
+	if len(namespaces.Include) == 0 {
+		// The API server filters out the excluded namespaces, the informers
+		// still check them in case it does not.
+		var sels []fields.Selector
+		if selector != nil {
+			sels = append(sels, selector)
+		}
+		for _, ns := range namespaces.Exclude {
+			sels = append(sels, fields.OneTermNotEqualSelector("metadata.namespace", ns))
+		}
+		i.factories = append(i.factories, kubeinformers.NewSharedInformerFactoryWithOptions(client, resync, tweak(fields.AndSelectors(sels...))))
+		return i
+	}
+	for _, ns := range namespaces.Include {
+		i.factories = append(i.factories, kubeinformers.NewSharedInformerFactoryWithOptions(client, resync, kubeinformers.WithNamespace(ns), tweak(selector)))
+	}
+	return i
+}
+
+// Start starts all the informers requested so far.
+func (i *namespaceInformers) Start(stopCh <-chan struct{}) {
+	for _, f := range i.factories {
+		f.Start(stopCh)
+	}
+}
+
+func (i *namespaceInformers) informer(get func(kubeinformers.SharedInformerFactory) cache.SharedIndexInformer) *multiNamespaceInformer {
+	m := &multiNamespaceInformer{namespaces: i.namespaces}
+	for _, f := range i.factories {
+		m.informers = append(m.informers, get(f))
+	}
+	return m
+}
+
+func (i *namespaceInformers) Pods() corev1informers.PodInformer {
+	return podInformer{i.informer(func(f kubeinformers.SharedInformerFactory) cache.SharedIndexInformer {
+		return f.Core().V1().Pods().Informer()
+	})}
+}
+
+func (i *namespaceInformers) Secrets() corev1informers.SecretInformer {
+	return secretInformer{i.informer(func(f kubeinformers.SharedInformerFactory) cache.SharedIndexInformer {
+		return f.Core().V1().Secrets().Informer()
+	})}
+}
+
+func (i *namespaceInformers) ConfigMaps() corev1informers.ConfigMapInformer {
+	return configMapInformer{i.informer(func(f kubeinformers.SharedInformerFactory) cache.SharedIndexInformer {
+		return f.Core().V1().ConfigMaps().Informer()
+	})}
+}
+
+func (i *namespaceInformers) Services() corev1informers.ServiceInformer {
+	return serviceInformer{i.informer(func(f kubeinformers.SharedInformerFactory) cache.SharedIndexInformer {
+		return f.Core().V1().Services().Informer()
+	})}
+}
+
+func (i *namespaceInformers) PersistentVolumeClaims() corev1informers.PersistentVolumeClaimInformer {
+	return pvcInformer{i.informer(func(f kubeinformers.SharedInformerFactory) cache.SharedIndexInformer {
+		return f.Core().V1().PersistentVolumeClaims().Informer()
+	})}
+}
+
+type podInformer struct{ i *multiNamespaceInformer }
+
+func (p podInformer) Informer() cache.SharedIndexInformer { return p.i }
+func (p podInformer) Lister() corev1listers.PodLister {
+	return corev1listers.NewPodLister(p.i.GetIndexer())
+}
+
+type secretInformer struct{ i *multiNamespaceInformer }
+
+func (s secretInformer) Informer() cache.SharedIndexInformer { return s.i }
+func (s secretInformer) Lister() corev1listers.SecretLister {
+	return corev1listers.NewSecretLister(s.i.GetIndexer())
+}
+
+type configMapInformer struct{ i *multiNamespaceInformer }
+
+func (c configMapInformer) Informer() cache.SharedIndexInformer { return c.i }
+func (c configMapInformer) Lister() corev1listers.ConfigMapLister {
+	return corev1listers.NewConfigMapLister(c.i.GetIndexer())
+}
+
+type serviceInformer struct{ i *multiNamespaceInformer }
+
+func (s serviceInformer) Informer() cache.SharedIndexInformer { return s.i }
+func (s serviceInformer) Lister() corev1listers.ServiceLister {
+	return corev1listers.NewServiceLister(s.i.GetIndexer())
+}
+
+type pvcInformer struct{ i *multiNamespaceInformer }
+
+func (p pvcInformer) Informer() cache.SharedIndexInformer { return p.i }
+func (p pvcInformer) Lister() corev1listers.PersistentVolumeClaimLister {
+	return corev1listers.NewPersistentVolumeClaimLister(p.i.GetIndexer())
+}
+
+// multiNamespaceInformer combines the informers of a resource for several
+// namespaces into a single informer, ignoring objects outside of namespaces.
+type multiNamespaceInformer struct {
+	namespaces opts.NamespaceSet
+	informers  []cache.SharedIndexInformer
+}
+
+var _ cache.SharedIndexInformer = &multiNamespaceInformer{}
+
+func (m *multiNamespaceInformer) filter(h cache.ResourceEventHandler) cache.ResourceEventHandler {
+	return cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			return err == nil && m.namespaces.Has(keyNamespace(key))
+		},
+		Handler: h,
+	}
+}
+
+func (m *multiNamespaceInformer) AddEventHandler(h cache.ResourceEventHandler) {
+	for _, i := range m.informers {
+		i.AddEventHandler(m.filter(h))
+	}
+}
+
+func (m *multiNamespaceInformer) AddEventHandlerWithResyncPeriod(h cache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, i := range m.informers {
+		i.AddEventHandlerWithResyncPeriod(m.filter(h), resyncPeriod)
+	}
+}
+
+func (m *multiNamespaceInformer) GetStore() cache.Store {
+	return m.GetIndexer()
+}
+
+// GetController returns the informer itself.
+func (m *multiNamespaceInformer) GetController() cache.Controller {
+	return m
+}
+
+// Run waits until stopCh is closed.
+// The underlying informers are run by the factories which created them, see
+// `namespaceInformers.Start`, running them again would start a second
+// reflector and processor for each of them.
+func (m *multiNamespaceInformer) Run(stopCh <-chan struct{}) {
+	<-stopCh
+}
+
+func (m *multiNamespaceInformer) HasSynced() bool {
+	for _, i := range m.informers {
+		if !i.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// LastSyncResourceVersion returns the resource version of the underlying
+// informer when there is only one.
+// The informers of several namespaces each list and watch on their own, so
+// there is no resource version covering all of them and an empty string is
+// returned then.
+func (m *multiNamespaceInformer) LastSyncResourceVersion() string {
+	if len(m.informers) != 1 {
+		return ""
+	}
+	return m.informers[0].LastSyncResourceVersion()
+}
+
+func (m *multiNamespaceInformer) SetWatchErrorHandler(handler cache.WatchErrorHandler) error {
+	for _, i := range m.informers {
+		if err := i.SetWatchErrorHandler(handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *multiNamespaceInformer) AddIndexers(indexers cache.Indexers) error {
+	for _, i := range m.informers {
+		if err := i.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *multiNamespaceInformer) GetIndexer() cache.Indexer {
+	idx := &multiNamespaceIndexer{namespaces: m.namespaces}
+	for _, i := range m.informers {
+		idx.indexers = append(idx.indexers, i.GetIndexer())
+	}
+	return idx
+}
+
+// multiNamespaceIndexer is a read only view of the indexers of a
+// `multiNamespaceInformer`.
+type multiNamespaceIndexer struct {
+	namespaces opts.NamespaceSet
+	indexers   []cache.Indexer
+}
+
+var errReadOnlyIndexer = errors.New("the indexer of a multi namespace informer is read only")
+
+func (m *multiNamespaceIndexer) Add(interface{}) error               { return errReadOnlyIndexer }
+func (m *multiNamespaceIndexer) Update(interface{}) error            { return errReadOnlyIndexer }
+func (m *multiNamespaceIndexer) Delete(interface{}) error            { return errReadOnlyIndexer }
+func (m *multiNamespaceIndexer) Replace([]interface{}, string) error { return errReadOnlyIndexer }
+func (m *multiNamespaceIndexer) Resync() error                       { return errReadOnlyIndexer }
+
+func (m *multiNamespaceIndexer) AddIndexers(indexers cache.Indexers) error {
+	for _, i := range m.indexers {
+		if err := i.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetIndexers returns the indexers of all the underlying indexers.
+func (m *multiNamespaceIndexer) GetIndexers() cache.Indexers {
+	merged := cache.Indexers{}
+	for _, i := range m.indexers {
+		for name, f := range i.GetIndexers() {
+			merged[name] = f
+		}
+	}
+	return merged
+}
+
+// objects keeps the objects in the watched namespaces.
+func (m *multiNamespaceIndexer) objects(objs []interface{}) []interface{} {
+	out := objs[:0]
+	for _, obj := range objs {
+		key, err := cache.MetaNamespaceKeyFunc(obj)
+		if err == nil && m.namespaces.Has(keyNamespace(key)) {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// keys keeps the keys of objects in the watched namespaces.
+func (m *multiNamespaceIndexer) keys(keys []string) []string {
+	out := keys[:0]
+	for _, key := range keys {
+		if m.namespaces.Has(keyNamespace(key)) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func (m *multiNamespaceIndexer) List() []interface{} {
+	var objs []interface{}
+	for _, i := range m.indexers {
+		objs = append(objs, i.List()...)
+	}
+	return m.objects(objs)
+}
+
+func (m *multiNamespaceIndexer) ListKeys() []string {
+	var keys []string
+	for _, i := range m.indexers {
+		keys = append(keys, i.ListKeys()...)
+	}
+	return m.keys(keys)
+}
+
+func (m *multiNamespaceIndexer) Get(obj interface{}) (interface{}, bool, error) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, false, cache.KeyError{Obj: obj, Err: err}
+	}
+	return m.GetByKey(key)
+}
+
+func (m *multiNamespaceIndexer) GetByKey(key string) (interface{}, bool, error) {
+	if !m.namespaces.Has(keyNamespace(key)) {
+		return nil, false, nil
+	}
+	for _, i := range m.indexers {
+		obj, exists, err := i.GetByKey(key)
+		if err != nil || exists {
+			return obj, exists, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (m *multiNamespaceIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	var objs []interface{}
+	for _, i := range m.indexers {
+		l, err := i.Index(indexName, obj)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, l...)
+	}
+	return m.objects(objs), nil
+}
+
+func (m *multiNamespaceIndexer) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	var keys []string
+	for _, i := range m.indexers {
+		l, err := i.IndexKeys(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, l...)
+	}
+	return m.keys(keys), nil
+}
+
+func (m *multiNamespaceIndexer) ListIndexFuncValues(indexName string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, i := range m.indexers {
+		for _, v := range i.ListIndexFuncValues(indexName) {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func (m *multiNamespaceIndexer) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	var objs []interface{}
+	for _, i := range m.indexers {
+		l, err := i.ByIndex(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, l...)
+	}
+	return m.objects(objs), nil
+}
+
+// keyNamespace returns the namespace part of an object key, or an empty
+// string for cluster scoped objects.
+func keyNamespace(key string) string {
+	ns, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return ""
+	}
+	return ns
+}
+
+// namespaceEventSink drops the events of namespaced objects which are not in
+// namespaces, so the events recorded match what is watched.
+// Events of cluster scoped objects, such as the node, are always kept.
+type namespaceEventSink struct {
+	record.EventSink
+	namespaces opts.NamespaceSet
+}
+
+func (s namespaceEventSink) dropped(e *corev1.Event) bool {
+	if e.InvolvedObject.Namespace == "" || s.namespaces.Has(e.Namespace) {
+		return false
+	}
+	log.L.WithField("namespace", e.Namespace).WithField("reason", e.Reason).Debug("Dropping event outside of the watched namespaces")
+	return true
+}
+
+func (s namespaceEventSink) Create(e *corev1.Event) (*corev1.Event, error) {
+	if s.dropped(e) {
+		return e, nil
+	}
+	return s.EventSink.Create(e)
+}
+
+func (s namespaceEventSink) Update(e *corev1.Event) (*corev1.Event, error) {
+	if s.dropped(e) {
+		return e, nil
+	}
+	return s.EventSink.Update(e)
+}
+
+func (s namespaceEventSink) Patch(e *corev1.Event, data []byte) (*corev1.Event, error) {
+	if s.dropped(e) {
+		return e, nil
+	}
+	return s.EventSink.Patch(e, data)
+}
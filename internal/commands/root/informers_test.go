@@ -0,0 +1,181 @@
+package root
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNamespaceInformers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		set      opts.NamespaceSet
+		expected []string
+	}{
+		{name: "all", expected: []string{"a/pod", "b/pod", "kube-system/pod"}},
+		{name: "include", set: opts.NamespaceSet{Include: []string{"a", "b"}}, expected: []string{"a/pod", "b/pod"}},
+		{name: "exclude", set: opts.NamespaceSet{Exclude: []string{"kube-system"}}, expected: []string{"a/pod", "b/pod"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := fake.NewSimpleClientset()
+			for _, ns := range []string{"a", "b", "kube-system"} {
+				_, err := client.CoreV1().Pods(ns).Create(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "pod"}}, metav1.CreateOptions{})
+				assert.NilError(t, err)
+				_, err = client.CoreV1().Secrets(ns).Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: "secret"}}, metav1.CreateOptions{})
+				assert.NilError(t, err)
+			}
+
+			informers := newNamespaceInformers(client, time.Minute, tc.set, nil)
+			podInformer := informers.Pods()
+			secretInformer := informers.Secrets()
+
+			var (
+				mu    sync.Mutex
+				added []string
+			)
+			podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					key, _ := cache.MetaNamespaceKeyFunc(obj)
+					mu.Lock()
+					added = append(added, key)
+					mu.Unlock()
+				},
+			})
+
+			informers.Start(ctx.Done())
+			assert.Assert(t, cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced, secretInformer.Informer().HasSynced))
+
+			pods, err := podInformer.Lister().List(labels.Everything())
+			assert.NilError(t, err)
+			assert.Check(t, is.DeepEqual(podKeys(pods), tc.expected))
+
+			for _, ns := range []string{"a", "b", "kube-system"} {
+				watched := tc.set.Has(ns)
+
+				_, err := podInformer.Lister().Pods(ns).Get("pod")
+				assert.Check(t, is.Equal(err == nil, watched), "%s: %v", ns, err)
+				assert.Check(t, watched || k8serrors.IsNotFound(err), "%s: %v", ns, err)
+
+				pods, err := podInformer.Lister().Pods(ns).List(labels.Everything())
+				assert.NilError(t, err)
+				assert.Check(t, is.Equal(len(pods) == 1, watched), ns)
+
+				_, err = secretInformer.Lister().Secrets(ns).Get("secret")
+				assert.Check(t, is.Equal(err == nil, watched), "%s: %v", ns, err)
+			}
+
+			mu.Lock()
+			sort.Strings(added)
+			assert.Check(t, is.DeepEqual(added, tc.expected))
+			mu.Unlock()
+		})
+	}
+}
+
+func TestMultiNamespaceInformer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	informers := newNamespaceInformers(client, time.Minute, opts.NamespaceSet{Include: []string{"a", "b"}}, nil)
+	m := informers.Pods().Informer().(*multiNamespaceInformer)
+	assert.Assert(t, is.Len(m.informers, 2))
+
+	// Indexers added to a single namespace are still listed
+	assert.NilError(t, m.AddIndexers(cache.Indexers{"all": func(interface{}) ([]string, error) { return nil, nil }}))
+	assert.NilError(t, m.informers[1].AddIndexers(cache.Indexers{"b": func(interface{}) ([]string, error) { return nil, nil }}))
+	var names []string
+	for name := range m.GetIndexer().GetIndexers() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Check(t, is.DeepEqual(names, []string{"all", "b", cache.NamespaceIndex}))
+
+	informers.Start(ctx.Done())
+	assert.Assert(t, cache.WaitForCacheSync(ctx.Done(), m.HasSynced))
+	assert.Check(t, is.Equal(m.LastSyncResourceVersion(), ""))
+
+	// The informers are already running, Run only waits for the stop channel
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		m.GetController().Run(stopCh)
+		close(done)
+	}()
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after the stop channel was closed")
+	}
+}
+
+func podKeys(pods []*corev1.Pod) []string {
+	var keys []string
+	for _, p := range pods {
+		keys = append(keys, p.Namespace+"/"+p.Name)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type recordingEventSink struct {
+	events []*corev1.Event
+}
+
+func (s *recordingEventSink) Create(e *corev1.Event) (*corev1.Event, error) {
+	s.events = append(s.events, e)
+	return e, nil
+}
+
+func (s *recordingEventSink) Update(e *corev1.Event) (*corev1.Event, error) {
+	return s.Create(e)
+}
+
+func (s *recordingEventSink) Patch(e *corev1.Event, _ []byte) (*corev1.Event, error) {
+	return s.Create(e)
+}
+
+func TestNamespaceEventSink(t *testing.T) {
+	rec := &recordingEventSink{}
+	sink := namespaceEventSink{EventSink: rec, namespaces: opts.NamespaceSet{Include: []string{"a"}}}
+
+	event := func(ns, objectNamespace string) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: ns, Name: "event"},
+			InvolvedObject: corev1.ObjectReference{Namespace: objectNamespace},
+		}
+	}
+
+	for _, e := range []*corev1.Event{
+		event("a", "a"),
+		event("b", "b"),
+		// Events about the node are not namespaced.
+		event(metav1.NamespaceDefault, ""),
+	} {
+		_, err := sink.Create(e)
+		assert.NilError(t, err)
+		_, err = sink.Patch(e, nil)
+		assert.NilError(t, err)
+	}
+
+	assert.Assert(t, is.Len(rec.events, 4))
+	for _, e := range rec.events {
+		assert.Check(t, e.Namespace != "b")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// runWithLeaderElection calls lead once this instance, identified by id, is
+// elected leader, and waits for it to return.
+//
+// The context passed to lead is cancelled when ctx is, or when leadership is
+// lost. In the latter case lead should return an error, which is returned,
+// as the instance must not keep running the node.
+// The leader election lease is released once lead returns after ctx is
+// cancelled, so a standby instance only takes over once lead is done.
+func runWithLeaderElection(ctx context.Context, client kubernetes.Interface, c *opts.Opts, id string, lead func(context.Context) error) error {
+	namespace, name := c.LeaderElectionLease()
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("leaderElectionLease", namespace+"/"+name))
+
+	// The election outlives ctx, so the lease is held until lead returns.
+	electionCtx, cancelElection := context.WithCancel(log.WithLogger(context.Background(), log.G(ctx)))
+	defer cancelElection()
+
+	var (
+		leading = make(chan struct{})
+		done    = make(chan error, 1)
+	)
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: id},
+		},
+		LeaseDuration:   c.LeaderElectLeaseDuration,
+		RenewDeadline:   c.LeaderElectRenewDeadline,
+		RetryPeriod:     c.LeaderElectRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.G(ctx).Info("Elected leader, starting the node")
+				close(leading)
+
+				leaderCtx, cancel := context.WithCancel(leaderCtx)
+				defer cancel()
+				go func() {
+					select {
+					case <-ctx.Done():
+						cancel()
+					case <-leaderCtx.Done():
+					}
+				}()
+				done <- lead(log.WithLogger(leaderCtx, log.G(ctx)))
+			},
+			OnStoppedLeading: func() {},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					log.G(ctx).WithField("leader", identity).Info("Standing by, another instance is the leader")
+				}
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "error setting up leader election")
+	}
+
+	electionDone := make(chan struct{})
+	go func() {
+		le.Run(electionCtx)
+		close(electionDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		select {
+		case <-leading:
+			err = <-done
+		default:
+		}
+	case err = <-done:
+	}
+	cancelElection()
+	<-electionDone
+	return err
+}
@@ -0,0 +1,50 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// logrusEntry is implemented by the loggers of
+// github.com/virtual-kubelet/virtual-kubelet/log/logrus, which embed the
+// logrus entry they log to.
+type logrusEntry interface {
+	WithContext(context.Context) *logrus.Entry
+}
+
+// setLogLevel sets the level of the logger of ctx. An empty level leaves the
+// logger as is.
+func setLogLevel(ctx context.Context, level string) error {
+	if level == "" {
+		return nil
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return errdefs.AsInvalidInput(errors.Wrap(err, "error parsing log level"))
+	}
+	entry, ok := log.G(ctx).(logrusEntry)
+	if !ok {
+		log.G(ctx).Warnf("Cannot set the log level to %s, the logger is not a logrus logger", level)
+		return nil
+	}
+	entry.WithContext(ctx).Logger.SetLevel(lvl)
+	return nil
+}
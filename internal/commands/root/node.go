@@ -16,21 +16,99 @@ package root
 
 import (
 	"context"
+	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/virtual-kubelet/node-cli/opts"
 	"github.com/virtual-kubelet/node-cli/provider"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-const osLabel = "beta.kubernetes.io/os"
+// The deprecated os and arch labels, which are still set for older clients.
+const (
+	betaOSLabel   = "beta.kubernetes.io/os"
+	betaArchLabel = "beta.kubernetes.io/arch"
+)
+
+// NodeRegistration holds the user supplied metadata the node is registered
+// with, see `NodeFromProvider` for how it is merged.
+type NodeRegistration struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	Taints      []v1.Taint
+	// Architecture overrides the architecture set by the provider.
+	Architecture string
+	// ExtendedResources are added to the capacity and allocatable resources.
+	ExtendedResources v1.ResourceList
+	// Reserved is subtracted from the allocatable resources.
+	Reserved v1.ResourceList
+
+	// LabelPolicy and TaintPolicy decide which labels and taints the node
+	// may be registered with.
+	LabelPolicy opts.KeyPolicy
+	TaintPolicy opts.KeyPolicy
+	// StrictPolicy makes rejected labels and taints an error rather than
+	// dropping them.
+	StrictPolicy bool
+}
+
+// nodeRegistrationFromOpts parses the node labels, annotations and taints set
+// in the options.
+func nodeRegistrationFromOpts(o *opts.Opts) (NodeRegistration, error) {
+	var (
+		reg NodeRegistration
+		err error
+	)
+	if reg.Labels, err = opts.ParseNodeLabels(o.NodeLabels); err != nil {
+		return reg, err
+	}
+	if reg.Annotations, err = opts.ParseNodeAnnotations(o.NodeAnnotations); err != nil {
+		return reg, err
+	}
+	if reg.Taints, err = opts.ParseTaints(o.RegisterWithTaints); err != nil {
+		return reg, err
+	}
+	if reg.ExtendedResources, err = opts.ParseExtendedResources(o.ExtendedResources); err != nil {
+		return reg, err
+	}
+	if reg.Reserved, err = opts.ParseResourceList(o.SystemReserved); err != nil {
+		return reg, err
+	}
+	reg.Architecture = o.NodeArch
+	reg.LabelPolicy = o.NodeLabelPolicy()
+	reg.TaintPolicy = o.NodeTaintPolicy()
+	reg.StrictPolicy = o.StrictNodePolicy
+	return reg, nil
+}
 
 // NodeFromProvider builds a kubernetes node object from a provider
 // This is a temporary solution until node stuff actually split off from the provider interface itself.
-func NodeFromProvider(ctx context.Context, name string, taint *v1.Taint, p provider.Provider, version string) *v1.Node {
+//
+// The node metadata is built up in this order, later steps win over earlier
+// ones:
+//  1. the default labels and the virtual-kubelet taint (if not nil)
+//  2. whatever the provider sets in `ConfigureNode`
+//  3. the labels, annotations, taints and architecture in reg; a taint
+//     replaces any existing taint with the same key and effect, the
+//     architecture replaces the arch labels
+//  4. the architecture, `runtime.GOARCH`, only if it is still unset
+//  5. the os and arch labels, both the stable and the beta ones, only if
+//     they are still unset; a label which is set is copied to its unset
+//     counterpart, otherwise the value comes from the node info
+//  6. the extended resources in reg are set in the capacity and allocatable
+//     resources, replacing the quantities set by the provider
+//  7. the resources reserved in reg are subtracted from the allocatable
+//     resources, see `subtractReserved`
+//
+// Finally the labels and taints rejected by the policies in reg are logged
+// and removed, or returned as an error if reg.StrictPolicy is set.
+func NodeFromProvider(ctx context.Context, name string, taint *v1.Taint, p provider.Provider, version string, reg NodeRegistration) (*v1.Node, error) {
 	taints := make([]v1.Taint, 0)
 
 	if taint != nil {
@@ -51,25 +129,194 @@ func NodeFromProvider(ctx context.Context, name string, taint *v1.Taint, p provi
 		},
 		Status: v1.NodeStatus{
 			NodeInfo: v1.NodeSystemInfo{
-				Architecture:   "amd64",
 				KubeletVersion: version,
 			},
 		},
 	}
 
 	p.ConfigureNode(ctx, node)
-	if _, ok := node.ObjectMeta.Labels[osLabel]; !ok {
-		node.ObjectMeta.Labels[osLabel] = strings.ToLower(node.Status.NodeInfo.OperatingSystem)
+
+	if node.ObjectMeta.Labels == nil {
+		node.ObjectMeta.Labels = make(map[string]string, len(reg.Labels)+1)
+	}
+	for k, v := range reg.Labels {
+		node.ObjectMeta.Labels[k] = v
+	}
+	if len(reg.Annotations) > 0 && node.ObjectMeta.Annotations == nil {
+		node.ObjectMeta.Annotations = make(map[string]string, len(reg.Annotations))
+	}
+	for k, v := range reg.Annotations {
+		node.ObjectMeta.Annotations[k] = v
+	}
+	for i := range reg.Taints {
+		node.Spec.Taints = replaceTaint(node.Spec.Taints, &reg.Taints[i], &reg.Taints[i])
+	}
+
+	if reg.Architecture != "" {
+		node.Status.NodeInfo.Architecture = reg.Architecture
+		node.ObjectMeta.Labels[v1.LabelArchStable] = reg.Architecture
+		node.ObjectMeta.Labels[betaArchLabel] = reg.Architecture
+	}
+	if node.Status.NodeInfo.Architecture == "" {
+		node.Status.NodeInfo.Architecture = runtime.GOARCH
+	}
+
+	setLabelPair(node.ObjectMeta.Labels, v1.LabelOSStable, betaOSLabel, strings.ToLower(node.Status.NodeInfo.OperatingSystem))
+	setLabelPair(node.ObjectMeta.Labels, v1.LabelArchStable, betaArchLabel, node.Status.NodeInfo.Architecture)
+
+	addExtendedResources(node, reg.ExtendedResources)
+	subtractReserved(ctx, node, reg.Reserved)
+
+	if err := applyNodePolicy(ctx, node, reg); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// addExtendedResources sets the quantities of the extended resources in the
+// capacity and allocatable resources of node.
+func addExtendedResources(node *v1.Node, resources v1.ResourceList) {
+	if len(resources) == 0 {
+		return
+	}
+	if node.Status.Capacity == nil {
+		node.Status.Capacity = make(v1.ResourceList, len(resources))
+	}
+	if node.Status.Allocatable == nil {
+		node.Status.Allocatable = make(v1.ResourceList, len(resources))
+	}
+	for name, q := range resources {
+		node.Status.Capacity[name] = q.DeepCopy()
+		node.Status.Allocatable[name] = q.DeepCopy()
+	}
+}
+
+// subtractReserved subtracts the reserved resources from the allocatable
+// resources of node.
+// A resource the provider sets no allocatable quantity for is subtracted
+// from its capacity instead, as the kubelet does. Allocatable never goes
+// below zero, and reserved resources the node does not have are ignored.
+func subtractReserved(ctx context.Context, node *v1.Node, reserved v1.ResourceList) {
+	if len(reserved) == 0 {
+		return
+	}
+	if node.Status.Allocatable == nil {
+		node.Status.Allocatable = make(v1.ResourceList, len(node.Status.Capacity))
+	}
+	for name, r := range reserved {
+		q, ok := node.Status.Allocatable[name]
+		if !ok {
+			q, ok = node.Status.Capacity[name]
+		}
+		if !ok {
+			log.G(ctx).WithField("resource", name).Warn("Ignoring reserved resource the node does not have")
+			continue
+		}
+		q = q.DeepCopy()
+		q.Sub(r)
+		if q.Sign() < 0 {
+			q.Set(0)
+		}
+		node.Status.Allocatable[name] = q
+	}
+}
+
+// resourceNodeProvider applies the extended and reserved resources of the
+// registration, see `NodeFromProvider`, to every node status reported by
+// the wrapped provider too, so the status updates do not restore the raw
+// capacity and allocatable resources.
+// The provider is expected to report its own resources, without them.
+type resourceNodeProvider struct {
+	node.NodeProvider
+	extended v1.ResourceList
+	reserved v1.ResourceList
+}
+
+// newResourceNodeProvider wraps p, it returns p as is if there is nothing
+// to apply.
+func newResourceNodeProvider(p node.NodeProvider, extended, reserved v1.ResourceList) node.NodeProvider {
+	if len(extended) == 0 && len(reserved) == 0 {
+		return p
+	}
+	return &resourceNodeProvider{NodeProvider: p, extended: extended, reserved: reserved}
+}
+
+// NotifyNodeStatus implements node.NodeProvider.
+func (p *resourceNodeProvider) NotifyNodeStatus(ctx context.Context, cb func(*v1.Node)) {
+	p.NodeProvider.NotifyNodeStatus(ctx, func(n *v1.Node) {
+		n = n.DeepCopy()
+		addExtendedResources(n, p.extended)
+		subtractReserved(ctx, n, p.reserved)
+		cb(n)
+	})
+}
+
+// applyNodePolicy removes the labels and taints of node which are rejected
+// by the policies in reg.
+func applyNodePolicy(ctx context.Context, node *v1.Node, reg NodeRegistration) error {
+	var rejected []string
+	for k := range node.ObjectMeta.Labels {
+		if !reg.LabelPolicy.Allowed(k) {
+			rejected = append(rejected, "label "+k)
+		}
+	}
+	for _, t := range node.Spec.Taints {
+		if !reg.TaintPolicy.Allowed(t.Key) {
+			rejected = append(rejected, "taint "+t.Key)
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	sort.Strings(rejected)
+	if reg.StrictPolicy {
+		return errdefs.InvalidInputf("node %s has labels or taints rejected by the node policy: %s", node.Name, strings.Join(rejected, ", "))
+	}
+
+	for _, r := range rejected {
+		log.G(ctx).WithField("node", node.Name).Warnf("Not registering the node with %s, it is rejected by the node policy", r)
+	}
+	for k := range node.ObjectMeta.Labels {
+		if !reg.LabelPolicy.Allowed(k) {
+			delete(node.ObjectMeta.Labels, k)
+		}
+	}
+	taints := node.Spec.Taints[:0]
+	for _, t := range node.Spec.Taints {
+		if reg.TaintPolicy.Allowed(t.Key) {
+			taints = append(taints, t)
+		}
+	}
+	node.Spec.Taints = taints
+	return nil
+}
+
+// setLabelPair sets the stable and the beta label of the same thing to the
+// same value when unset.
+// The value of whichever label is set, the stable one first, is used before
+// falling back to value.
+func setLabelPair(labels map[string]string, stable, beta, value string) {
+	if v, ok := labels[beta]; ok {
+		value = v
+	}
+	if v, ok := labels[stable]; ok {
+		value = v
+	}
+	for _, l := range []string{stable, beta} {
+		if _, ok := labels[l]; !ok {
+			labels[l] = value
+		}
 	}
-	return node
 }
 
 // getTaint creates a taint using the provided key/value.
 // Taint effect is read from the environment
 // The taint key/value may be overwritten by the environment.
+// If no taint value is set the provider name is used.
 func getTaint(o *opts.Opts) (*corev1.Taint, error) {
-	if o.TaintValue == "" {
-		o.TaintValue = o.Provider
+	value := o.TaintValue
+	if value == "" {
+		value = o.Provider
 	}
 
 	var effect corev1.TaintEffect
@@ -86,7 +333,22 @@ func getTaint(o *opts.Opts) (*corev1.Taint, error) {
 
 	return &corev1.Taint{
 		Key:    o.TaintKey,
-		Value:  o.TaintValue,
+		Value:  value,
 		Effect: effect,
 	}, nil
 }
+
+// replaceTaint returns a copy of taints with old replaced by new.
+func replaceTaint(taints []corev1.Taint, old, new *corev1.Taint) []corev1.Taint {
+	out := make([]corev1.Taint, 0, len(taints)+1)
+	for _, t := range taints {
+		if old != nil && t.MatchTaint(old) {
+			continue
+		}
+		out = append(out, t)
+	}
+	if new != nil {
+		out = append(out, *new)
+	}
+	return out
+}
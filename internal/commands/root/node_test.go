@@ -0,0 +1,264 @@
+package root
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// configureNodeProvider is a provider which only implements ConfigureNode.
+type configureNodeProvider struct {
+	provider.Provider
+	configure func(*corev1.Node)
+}
+
+func (p configureNodeProvider) ConfigureNode(_ context.Context, n *corev1.Node) {
+	p.configure(n)
+}
+
+func TestNodeFromProviderRegistration(t *testing.T) {
+	vkTaint := &corev1.Taint{Key: "virtual-kubelet.io/provider", Value: "mock", Effect: corev1.TaintEffectNoSchedule}
+	p := configureNodeProvider{configure: func(n *corev1.Node) {
+		n.Status.NodeInfo.OperatingSystem = "Linux"
+		n.Labels["kubernetes.io/role"] = "provider"
+		n.Labels["from-provider"] = "provider"
+		n.Annotations = map[string]string{"from-provider": "provider"}
+		n.Spec.Taints = append(n.Spec.Taints, corev1.Taint{Key: "from-provider", Effect: corev1.TaintEffectNoExecute})
+	}}
+
+	n, err := NodeFromProvider(context.Background(), "node", vkTaint, p, "v1", NodeRegistration{
+		Labels:      map[string]string{"from-provider": "user", "beta.kubernetes.io/os": "custom"},
+		Annotations: map[string]string{"from-user": "user"},
+		Taints: []corev1.Taint{
+			{Key: "virtual-kubelet.io/provider", Value: "user", Effect: corev1.TaintEffectNoSchedule},
+			{Key: "from-user", Effect: corev1.TaintEffectPreferNoSchedule},
+		},
+	})
+	assert.NilError(t, err)
+
+	assert.Check(t, is.DeepEqual(n.Labels, map[string]string{
+		"type":                    "virtual-kubelet",
+		"kubernetes.io/role":      "provider",
+		"kubernetes.io/hostname":  "node",
+		"from-provider":           "user",
+		"beta.kubernetes.io/os":   "custom",
+		"kubernetes.io/os":        "custom",
+		"beta.kubernetes.io/arch": runtime.GOARCH,
+		"kubernetes.io/arch":      runtime.GOARCH,
+	}))
+	assert.Check(t, is.DeepEqual(n.Annotations, map[string]string{"from-provider": "provider", "from-user": "user"}))
+	assert.Check(t, is.DeepEqual(n.Spec.Taints, []corev1.Taint{
+		{Key: "from-provider", Effect: corev1.TaintEffectNoExecute},
+		{Key: "virtual-kubelet.io/provider", Value: "user", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "from-user", Effect: corev1.TaintEffectPreferNoSchedule},
+	}))
+}
+
+func TestNodeFromProviderArchitecture(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		provider string
+		label    string
+		reg      string
+		expected string
+	}{
+		{name: "default", expected: runtime.GOARCH},
+		{name: "provider", provider: "arm64", expected: "arm64"},
+		{name: "option", provider: "amd64", reg: "arm64", expected: "arm64"},
+		{name: "option and provider label", provider: "amd64", label: "amd64", reg: "arm64", expected: "arm64"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := configureNodeProvider{configure: func(n *corev1.Node) {
+				n.Status.NodeInfo.OperatingSystem = "Linux"
+				n.Status.NodeInfo.Architecture = tc.provider
+				if tc.label != "" {
+					n.Labels["kubernetes.io/arch"] = tc.label
+				}
+			}}
+
+			n, err := NodeFromProvider(context.Background(), "node", nil, p, "v1", NodeRegistration{Architecture: tc.reg})
+			assert.NilError(t, err)
+			assert.Check(t, is.Equal(n.Status.NodeInfo.Architecture, tc.expected))
+			assert.Check(t, is.Equal(n.Labels["kubernetes.io/arch"], tc.expected))
+			assert.Check(t, is.Equal(n.Labels["beta.kubernetes.io/arch"], tc.expected))
+			assert.Check(t, is.Equal(n.Labels["kubernetes.io/os"], "linux"))
+			assert.Check(t, is.Equal(n.Labels["beta.kubernetes.io/os"], "linux"))
+		})
+	}
+}
+
+func TestNodeFromProviderPolicy(t *testing.T) {
+	p := configureNodeProvider{configure: func(n *corev1.Node) {
+		n.Status.NodeInfo.OperatingSystem = "Linux"
+		n.Labels["node-role.kubernetes.io/agent"] = ""
+		n.Labels["node.kubernetes.io/pool"] = "virtual"
+		n.Spec.Taints = append(n.Spec.Taints, corev1.Taint{Key: "example.com/denied", Effect: corev1.TaintEffectNoSchedule})
+	}}
+	reg := NodeRegistration{
+		Labels:      map[string]string{"example.com/label": "user"},
+		LabelPolicy: (&opts.Opts{NodeRestriction: true}).NodeLabelPolicy(),
+		TaintPolicy: opts.KeyPolicy{Deny: []string{"example.com/*"}},
+	}
+
+	n, err := NodeFromProvider(context.Background(), "node", nil, p, "v1", reg)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(n.Labels, map[string]string{
+		"type":                    "virtual-kubelet",
+		"kubernetes.io/hostname":  "node",
+		"node.kubernetes.io/pool": "virtual",
+		"example.com/label":       "user",
+		"kubernetes.io/os":        "linux",
+		"beta.kubernetes.io/os":   "linux",
+		"kubernetes.io/arch":      runtime.GOARCH,
+		"beta.kubernetes.io/arch": runtime.GOARCH,
+	}))
+	assert.Check(t, is.Len(n.Spec.Taints, 0))
+
+	reg.StrictPolicy = true
+	_, err = NodeFromProvider(context.Background(), "node", nil, p, "v1", reg)
+	assert.Check(t, errdefs.IsInvalidInput(err))
+	assert.Check(t, is.ErrorContains(err, "label kubernetes.io/role, label node-role.kubernetes.io/agent, taint example.com/denied"))
+}
+
+func TestNodeFromProviderReserved(t *testing.T) {
+	p := configureNodeProvider{configure: func(n *corev1.Node) {
+		n.Status.NodeInfo.OperatingSystem = "Linux"
+		n.Status.Capacity = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("20"),
+			corev1.ResourceMemory: resource.MustParse("100Gi"),
+			corev1.ResourcePods:   resource.MustParse("20"),
+		}
+		n.Status.Allocatable = corev1.ResourceList{
+			corev1.ResourceCPU:  resource.MustParse("16"),
+			corev1.ResourcePods: resource.MustParse("20"),
+		}
+	}}
+	reserved, err := opts.ParseResourceList([]string{"cpu=500m", "memory=4Gi", "pods=30", "example.com/gpu=1"})
+	assert.NilError(t, err)
+
+	n, err := NodeFromProvider(context.Background(), "node", nil, p, "v1", NodeRegistration{Reserved: reserved})
+	assert.NilError(t, err)
+	for name, expected := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    "15500m",
+		corev1.ResourceMemory: "96Gi",
+		corev1.ResourcePods:   "0",
+	} {
+		q := n.Status.Allocatable[name]
+		assert.Check(t, q.Cmp(resource.MustParse(expected)) == 0, "%s: expected %s, got %s", name, expected, q.String())
+	}
+	assert.Check(t, is.Len(n.Status.Allocatable, 3))
+	// Capacity is left as the provider set it
+	q := n.Status.Capacity[corev1.ResourceCPU]
+	assert.Check(t, is.Equal(q.String(), "20"))
+}
+
+func TestResourceNodeProviderReserved(t *testing.T) {
+	ctx := context.Background()
+	reserved, err := opts.ParseResourceList([]string{"cpu=500m"})
+	assert.NilError(t, err)
+	inner := &notifyingNodeProvider{}
+	p := newResourceNodeProvider(inner, nil, reserved)
+
+	var got *corev1.Node
+	p.NotifyNodeStatus(ctx, func(n *corev1.Node) { got = n })
+	update := &corev1.Node{Status: corev1.NodeStatus{
+		Capacity:    corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("20")},
+		Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("16")},
+	}}
+	inner.notify(update)
+
+	// Status updates get the reserved resources subtracted, like the registered node
+	assert.Assert(t, got != nil)
+	q := got.Status.Allocatable[corev1.ResourceCPU]
+	assert.Check(t, is.Equal(q.String(), "15500m"))
+	q = update.Status.Allocatable[corev1.ResourceCPU]
+	assert.Check(t, is.Equal(q.String(), "16"), "the update of the provider was modified")
+
+	assert.Check(t, is.Equal(newResourceNodeProvider(inner, nil, nil), node.NodeProvider(inner)))
+}
+
+func TestResourceNodeProviderExtendedResources(t *testing.T) {
+	ctx := context.Background()
+	extended, err := opts.ParseExtendedResources([]string{"example.com/license=10"})
+	assert.NilError(t, err)
+	reserved, err := opts.ParseResourceList([]string{"example.com/license=1"})
+	assert.NilError(t, err)
+	inner := &notifyingNodeProvider{}
+	p := newResourceNodeProvider(inner, extended, reserved)
+
+	var got *corev1.Node
+	p.NotifyNodeStatus(ctx, func(n *corev1.Node) { got = n })
+	inner.notify(&corev1.Node{Status: corev1.NodeStatus{
+		Capacity: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("20")},
+	}})
+
+	// The extended resources are still advertised after a status update
+	assert.Assert(t, got != nil)
+	c, a := got.Status.Capacity["example.com/license"], got.Status.Allocatable["example.com/license"]
+	assert.Check(t, is.Equal(c.String(), "10"))
+	assert.Check(t, is.Equal(a.String(), "9"))
+}
+
+func TestNodeFromProviderExtendedResources(t *testing.T) {
+	p := configureNodeProvider{configure: func(n *corev1.Node) {
+		n.Status.Capacity = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("20"),
+			"example.com/license": resource.MustParse("1"),
+		}
+	}}
+	extended, err := opts.ParseExtendedResources([]string{"example.com/license=10", "example.com/queue=2"})
+	assert.NilError(t, err)
+	reserved, err := opts.ParseResourceList([]string{"example.com/queue=1"})
+	assert.NilError(t, err)
+
+	n, err := NodeFromProvider(context.Background(), "node", nil, p, "v1", NodeRegistration{ExtendedResources: extended, Reserved: reserved})
+	assert.NilError(t, err)
+	for name, expected := range map[corev1.ResourceName][2]string{
+		"example.com/license": {"10", "10"},
+		"example.com/queue":   {"2", "1"},
+	} {
+		c, a := n.Status.Capacity[name], n.Status.Allocatable[name]
+		assert.Check(t, is.Equal(c.String(), expected[0]), name)
+		assert.Check(t, is.Equal(a.String(), expected[1]), name)
+	}
+	q := n.Status.Capacity[corev1.ResourceCPU]
+	assert.Check(t, is.Equal(q.String(), "20"))
+}
+
+func TestReloadTaintPolicy(t *testing.T) {
+	ctx := context.Background()
+	old := corev1.Taint{Key: "virtual-kubelet.io/provider", Value: "mock", Effect: corev1.TaintEffectNoSchedule}
+	n := &virtualNode{
+		pNode: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}, Spec: corev1.NodeSpec{Taints: []corev1.Taint{old}}},
+		taint: &old,
+	}
+
+	o := opts.New()
+	o.Provider = "mock"
+	o.TaintKey = "example.com/reloaded"
+	o.DeniedNodeTaints = []string{"example.com/*"}
+
+	// The denied taint is dropped, the old one is removed all the same
+	assert.NilError(t, n.reloadTaint(ctx, o))
+	assert.Check(t, is.Len(n.node().Spec.Taints, 0))
+	assert.Check(t, n.taint == nil)
+
+	// A strict policy rejects the reload and keeps the node as is
+	n.pNode.Spec.Taints = []corev1.Taint{old}
+	n.taint = &old
+	o.StrictNodePolicy = true
+	err := n.reloadTaint(ctx, o)
+	assert.Check(t, errdefs.IsInvalidInput(err))
+	assert.Check(t, is.DeepEqual(n.node().Spec.Taints, []corev1.Taint{old}))
+	assert.Check(t, is.DeepEqual(n.taint, &old))
+}
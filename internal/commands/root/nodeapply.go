@@ -0,0 +1,218 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// fieldManager is the field manager virtual-kubelet writes the node with.
+const fieldManager = "virtual-kubelet"
+
+// lastAppliedRegistrationAnnotation records the labels, annotations and
+// taints virtual-kubelet last registered the node with, so the ones it no
+// longer registers can be removed without touching the ones set by others.
+const lastAppliedRegistrationAnnotation = "virtual-kubelet.io/last-applied-registration"
+
+// nodeRegistration is the part of the node managed by virtual-kubelet.
+type nodeRegistration struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Taints      []corev1.Taint    `json:"taints,omitempty"`
+}
+
+func registrationOf(n *corev1.Node) nodeRegistration {
+	reg := nodeRegistration{
+		Labels: n.Labels,
+		Taints: n.Spec.Taints,
+	}
+	for k, v := range n.Annotations {
+		if k == lastAppliedRegistrationAnnotation {
+			continue
+		}
+		if reg.Annotations == nil {
+			reg.Annotations = make(map[string]string, len(n.Annotations))
+		}
+		reg.Annotations[k] = v
+	}
+	return reg
+}
+
+func lastAppliedRegistration(n *corev1.Node) nodeRegistration {
+	var reg nodeRegistration
+	if v, ok := n.Annotations[lastAppliedRegistrationAnnotation]; ok {
+		// A corrupted annotation only means stale keys are not removed.
+		_ = json.Unmarshal([]byte(v), &reg)
+	}
+	return reg
+}
+
+// applyNode registers the node with the labels, annotations and taints of
+// desired.
+//
+// The node is created if it does not exist. Otherwise it is patched: the
+// labels, annotations and taints virtual-kubelet registered the node with
+// last time and no longer does are removed, the ones of desired are set,
+// and everything else, such as the labels and taints added by other
+// controllers or the node being cordoned, is left as is.
+func applyNode(ctx context.Context, nodes corev1client.NodeInterface, desired *corev1.Node) (*corev1.Node, error) {
+	reg := registrationOf(desired)
+	b, err := json.Marshal(reg)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding node registration")
+	}
+
+	var applied *corev1.Node
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := nodes.Get(ctx, desired.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			n := desired.DeepCopy()
+			n.ResourceVersion = ""
+			n.Annotations = mergeStrings(n.Annotations, map[string]string{lastAppliedRegistrationAnnotation: string(b)})
+			applied, err = nodes.Create(ctx, n, metav1.CreateOptions{FieldManager: fieldManager})
+			if err == nil {
+				log.G(ctx).Debug("Created node")
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		last := lastAppliedRegistration(current)
+		n := current.DeepCopy()
+		n.Labels = applyStrings(n.Labels, last.Labels, reg.Labels)
+		n.Annotations = applyStrings(n.Annotations, last.Annotations, reg.Annotations)
+		n.Annotations[lastAppliedRegistrationAnnotation] = string(b)
+		n.Spec.Taints = applyTaints(n.Spec.Taints, last.Taints, reg.Taints)
+
+		patch, err := nodePatch(current, n)
+		if err != nil {
+			return err
+		}
+		if patch == nil {
+			applied = current
+			return nil
+		}
+		applied, err = nodes.Patch(ctx, desired.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error applying node")
+	}
+	return applied, nil
+}
+
+// nodePatch returns the strategic merge patch from current to modified, or
+// nil if there is nothing to change.
+// The patch is conditioned on the resource version of current, the same as
+// an update.
+func nodePatch(current, modified *corev1.Node) ([]byte, error) {
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding node")
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding node")
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(currentJSON, modifiedJSON, corev1.Node{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating node patch")
+	}
+	if string(patch) == "{}" {
+		return nil, nil
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(patch, &m); err != nil {
+		return nil, errors.Wrap(err, "error decoding node patch")
+	}
+	meta, _ := m["metadata"].(map[string]interface{})
+	if meta == nil {
+		meta = make(map[string]interface{}, 1)
+		m["metadata"] = meta
+	}
+	meta["resourceVersion"] = current.ResourceVersion
+	return json.Marshal(m)
+}
+
+// applyStrings returns current without the keys of last missing from
+// desired, and with the entries of desired set.
+func applyStrings(current, last, desired map[string]string) map[string]string {
+	out := make(map[string]string, len(current)+len(desired))
+	for k, v := range current {
+		if _, ok := last[k]; ok {
+			if _, ok := desired[k]; !ok {
+				continue
+			}
+		}
+		out[k] = v
+	}
+	for k, v := range desired {
+		out[k] = v
+	}
+	return out
+}
+
+func mergeStrings(m, extra map[string]string) map[string]string {
+	out := make(map[string]string, len(m)+len(extra))
+	for k, v := range m {
+		out[k] = v
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
+}
+
+// applyTaints returns current without the taints of last missing from
+// desired, and with the taints of desired set.
+// Taints are matched by key and effect.
+func applyTaints(current, last, desired []corev1.Taint) []corev1.Taint {
+	out := current
+	for i := range last {
+		if findTaint(desired, &last[i]) == nil {
+			out = replaceTaint(out, &last[i], nil)
+		}
+	}
+	for i := range desired {
+		if t := findTaint(out, &desired[i]); t != nil && t.Value == desired[i].Value {
+			continue
+		}
+		out = replaceTaint(out, &desired[i], &desired[i])
+	}
+	return out
+}
+
+func findTaint(taints []corev1.Taint, t *corev1.Taint) *corev1.Taint {
+	for i := range taints {
+		if taints[i].MatchTaint(t) {
+			return &taints[i]
+		}
+	}
+	return nil
+}
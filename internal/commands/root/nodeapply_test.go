@@ -0,0 +1,106 @@
+package root
+
+import (
+	"context"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newDesiredNode(labels, annotations map[string]string, taints ...corev1.Taint) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels, Annotations: annotations},
+		Spec:       corev1.NodeSpec{Taints: taints},
+	}
+}
+
+func TestApplyNode(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	nodes := client.CoreV1().Nodes()
+
+	vk := corev1.Taint{Key: "virtual-kubelet.io/provider", Value: "mock", Effect: corev1.TaintEffectNoSchedule}
+	foreign := corev1.Taint{Key: "foreign", Effect: corev1.TaintEffectNoExecute}
+
+	// Register the node
+	n, err := applyNode(ctx, nodes, newDesiredNode(
+		map[string]string{"type": "virtual-kubelet", "old": "label"},
+		map[string]string{"vk": "annotation"},
+		vk,
+	))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(n.Labels["old"], "label"))
+	assert.Check(t, is.Contains(n.Annotations, lastAppliedRegistrationAnnotation))
+
+	// Other controllers change the node
+	n.Labels["autoscaler"] = "label"
+	n.Annotations["autoscaler"] = "annotation"
+	n.Spec.Taints = append(n.Spec.Taints, foreign)
+	n.Spec.Unschedulable = true
+	_, err = nodes.Update(ctx, n, metav1.UpdateOptions{})
+	assert.NilError(t, err)
+
+	// Register the node again with a label removed and the taint changed
+	newVK := vk
+	newVK.Value = "new"
+	n, err = applyNode(ctx, nodes, newDesiredNode(
+		map[string]string{"type": "virtual-kubelet"},
+		map[string]string{"vk": "annotation"},
+		newVK,
+	))
+	assert.NilError(t, err)
+
+	n, err = nodes.Get(ctx, "node", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(n.Labels, map[string]string{"type": "virtual-kubelet", "autoscaler": "label"}))
+	assert.Check(t, is.Equal(n.Annotations["vk"], "annotation"))
+	assert.Check(t, is.Equal(n.Annotations["autoscaler"], "annotation"))
+	assert.Check(t, is.DeepEqual(n.Spec.Taints, []corev1.Taint{foreign, newVK}))
+	assert.Check(t, n.Spec.Unschedulable)
+
+	// Nothing changes when registering the same node again
+	rv := n.ResourceVersion
+	n, err = applyNode(ctx, nodes, newDesiredNode(
+		map[string]string{"type": "virtual-kubelet"},
+		map[string]string{"vk": "annotation"},
+		newVK,
+	))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(n.ResourceVersion, rv))
+}
+
+func TestApplyNodeNotRegisteredBefore(t *testing.T) {
+	ctx := context.Background()
+
+	// A node without the last applied annotation, e.g. created by an older
+	// version, keeps all its labels and taints.
+	foreign := corev1.Taint{Key: "foreign", Effect: corev1.TaintEffectNoExecute}
+	client := fake.NewSimpleClientset(newDesiredNode(map[string]string{"other": "label"}, nil, foreign))
+	nodes := client.CoreV1().Nodes()
+
+	vk := corev1.Taint{Key: "vk", Effect: corev1.TaintEffectNoSchedule}
+	_, err := applyNode(ctx, nodes, newDesiredNode(map[string]string{"type": "virtual-kubelet"}, nil, vk))
+	assert.NilError(t, err)
+
+	n, err := nodes.Get(ctx, "node", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(n.Labels, map[string]string{"type": "virtual-kubelet", "other": "label"}))
+	assert.Check(t, is.DeepEqual(n.Spec.Taints, []corev1.Taint{foreign, vk}))
+}
+
+func TestApplyTaints(t *testing.T) {
+	a := corev1.Taint{Key: "a", Effect: corev1.TaintEffectNoSchedule}
+	b := corev1.Taint{Key: "b", Effect: corev1.TaintEffectNoSchedule}
+	other := corev1.Taint{Key: "other", Effect: corev1.TaintEffectNoExecute}
+	newA := a
+	newA.Value = "new"
+
+	assert.Check(t, is.DeepEqual(applyTaints(nil, nil, []corev1.Taint{a}), []corev1.Taint{a}))
+	assert.Check(t, is.DeepEqual(applyTaints([]corev1.Taint{a, other}, []corev1.Taint{a}, nil), []corev1.Taint{other}))
+	assert.Check(t, is.DeepEqual(applyTaints([]corev1.Taint{a, other}, []corev1.Taint{a}, []corev1.Taint{newA}), []corev1.Taint{other, newA}))
+	assert.Check(t, is.DeepEqual(applyTaints([]corev1.Taint{a, other}, []corev1.Taint{a}, []corev1.Taint{a, b}), []corev1.Taint{a, other, b}))
+}
@@ -0,0 +1,331 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Annotations recording the instance running a node.
+const (
+	instanceIDAnnotation        = "virtual-kubelet.io/instance-id"
+	instanceHostAnnotation      = "virtual-kubelet.io/instance-host"
+	instanceStartTimeAnnotation = "virtual-kubelet.io/instance-start-time"
+)
+
+// ownershipConflictReason is the reason of the event recorded when another
+// instance holds the node.
+const ownershipConflictReason = "NodeOwnershipConflict"
+
+// leaseCheckInterval is how often a running node checks it still holds its
+// lease.
+var leaseCheckInterval = 10 * time.Second
+
+// leaseRenewWait is how long a starting instance waits for the lease held by
+// another instance on the same host to be renewed before taking it over. It
+// is longer than the interval the node controller renews the lease at.
+var leaseRenewWait = 15 * time.Second
+
+// instance identifies a running virtual-kubelet process.
+//
+// The ID is unique to the process, the node leases are held by an identity
+// derived from the host and the ID, see `holderIdentity`.
+type instance struct {
+	ID        string
+	Host      string
+	StartTime time.Time
+}
+
+func newInstance() instance {
+	host, _ := os.Hostname()
+	return instance{
+		ID:        string(uuid.NewUUID()),
+		Host:      host,
+		StartTime: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// annotations returns the annotations recording i as the owner of a node.
+func (i instance) annotations() map[string]string {
+	return map[string]string{
+		instanceIDAnnotation:        i.ID,
+		instanceHostAnnotation:      i.Host,
+		instanceStartTimeAnnotation: i.StartTime.Format(time.RFC3339),
+	}
+}
+
+// holderIdentity is the holder of the node leases of i.
+func (i instance) holderIdentity() string {
+	if i.Host == "" {
+		return i.ID
+	}
+	return i.Host + "_" + i.ID
+}
+
+// sameHost reports whether the lease holder is an instance on the host of i.
+func (i instance) sameHost(holder string) bool {
+	return i.Host != "" && strings.HasPrefix(holder, i.Host+"_")
+}
+
+func (i instance) String() string {
+	s := i.ID
+	if i.Host != "" {
+		s += " on " + i.Host
+	}
+	if !i.StartTime.IsZero() {
+		s += " started at " + i.StartTime.Format(time.RFC3339)
+	}
+	return s
+}
+
+// claimNode makes self the owner of the node.
+//
+// With node leases, the lease holder identity is the `holderIdentity` of the
+// instance running the node. If the lease is held by another instance and
+// has not expired, an event is recorded and claimNode fails, unless force is
+// set. A lease held by another instance on the same host is taken over if it
+// is not renewed within `leaseRenewWait`: that instance is the one this
+// instance restarts, so the lease is taken back without waiting for it to
+// expire.
+// Without node leases only the ownership annotations are set.
+//
+// An instance on another host which did not shut down cleanly keeps holding
+// the lease until it expires.
+func claimNode(ctx context.Context, client kubernetes.Interface, name string, self instance, lease, force bool) error {
+	nodes := client.CoreV1().Nodes()
+	n, err := nodes.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return errors.Wrap(err, "error getting node")
+	}
+	if err != nil {
+		n = nil
+	}
+
+	if lease {
+		if err := claimLease(ctx, client, n, name, self, force); err != nil {
+			return err
+		}
+	}
+
+	if n == nil {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := nodes.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if n.Annotations == nil {
+			n.Annotations = make(map[string]string, 3)
+		}
+		for k, v := range self.annotations() {
+			n.Annotations[k] = v
+		}
+		_, err = nodes.Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// claimLease sets the holder of the node lease to self.
+// n is the node as it is before it is claimed, it may be nil.
+func claimLease(ctx context.Context, client kubernetes.Interface, n *corev1.Node, name string, self instance, force bool) error {
+	leases := client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		l, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = leases.Create(ctx, &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Namespace: corev1.NamespaceNodeLease, Name: name},
+				Spec:       newLeaseSpec(self.holderIdentity()),
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		holder := leaseHolder(l)
+		if holder != "" && holder != self.holderIdentity() && leaseValid(l, time.Now()) && !force && self.sameHost(holder) {
+			renewed, err := leaseRenewed(ctx, leases, l)
+			if err != nil {
+				return err
+			}
+			if !renewed {
+				log.G(ctx).Infof("Lease of node %s held by instance %s is not renewed, taking it over", name, holder)
+				holder = ""
+			}
+		}
+		if holder != "" && holder != self.holderIdentity() && leaseValid(l, time.Now()) {
+			other := instance{ID: holder}
+			if n != nil {
+				annotated := instance{ID: n.Annotations[instanceIDAnnotation], Host: n.Annotations[instanceHostAnnotation]}
+				if annotated.ID != "" && annotated.holderIdentity() == holder {
+					other = annotated
+					other.StartTime, _ = time.Parse(time.RFC3339, n.Annotations[instanceStartTimeAnnotation])
+				}
+			}
+			msg := fmt.Sprintf("node %s is held by instance %s, refusing to start instance %s", name, other, self)
+			if force {
+				msg = fmt.Sprintf("instance %s took over node %s from instance %s", self, name, other)
+			}
+			recordOwnershipConflict(ctx, client, name, self, msg)
+			if !force {
+				return errors.New(msg + ", use --force-takeover to start anyway")
+			}
+			log.G(ctx).Warn(msg)
+		}
+
+		spec := newLeaseSpec(self.holderIdentity())
+		if l.Spec.LeaseDurationSeconds != nil {
+			spec.LeaseDurationSeconds = l.Spec.LeaseDurationSeconds
+		}
+		l.Spec = spec
+		_, err = leases.Update(ctx, l, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// leaseRenewed waits for `leaseRenewWait` and reports whether the lease l
+// was renewed or changed hands meanwhile.
+func leaseRenewed(ctx context.Context, leases coordinationv1client.LeaseInterface, l *coordinationv1.Lease) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(leaseRenewWait):
+	}
+	current, err := leases.Get(ctx, l.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	return leaseHolder(current) != leaseHolder(l) || !current.Spec.RenewTime.Equal(l.Spec.RenewTime), nil
+}
+
+func newLeaseSpec(holder string) coordinationv1.LeaseSpec {
+	duration := int32(node.DefaultLeaseDuration)
+	now := metav1.NewMicroTime(time.Now())
+	return coordinationv1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &duration,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+}
+
+func leaseHolder(l *coordinationv1.Lease) string {
+	if l.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *l.Spec.HolderIdentity
+}
+
+// leaseValid reports whether the lease has been renewed within its duration.
+func leaseValid(l *coordinationv1.Lease, now time.Time) bool {
+	if l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return l.Spec.RenewTime.Add(time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second).After(now)
+}
+
+// releaseLease clears the holder of the node lease if it is held by self, so
+// another instance may start without waiting for the lease to expire.
+func releaseLease(ctx context.Context, client kubernetes.Interface, name string, self instance) error {
+	leases := client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		l, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if leaseHolder(l) != self.holderIdentity() {
+			return nil
+		}
+		l.Spec.HolderIdentity = nil
+		_, err = leases.Update(ctx, l, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// waitLeaseLost checks the holder of the node lease until another instance
+// holds it, e.g. one started with `--force-takeover`, and returns an error
+// naming it. It returns nil once ctx is cancelled.
+//
+// The node controller renews the lease whoever holds it, so the holder is
+// checked separately.
+func waitLeaseLost(ctx context.Context, client kubernetes.Interface, name string, self instance) error {
+	leases := client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		l, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if ctx.Err() == nil && !k8serrors.IsNotFound(err) {
+				log.G(ctx).WithError(err).Warn("Error checking the holder of the node lease")
+			}
+			continue
+		}
+		// A lease created by the node controller is held by the node name,
+		// whichever instance runs it.
+		if holder := leaseHolder(l); holder != "" && holder != name && holder != self.holderIdentity() {
+			return errors.Errorf("node %s was taken over by instance %s", name, holder)
+		}
+	}
+}
+
+// recordOwnershipConflict records a warning event on the node.
+// The event is created directly rather than through an event recorder so it
+// is not lost when virtual-kubelet exits right after.
+func recordOwnershipConflict(ctx context.Context, client kubernetes.Interface, name string, self instance, msg string) {
+	now := metav1.NewTime(time.Now())
+	_, err := client.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    metav1.NamespaceDefault,
+			GenerateName: name + ".",
+		},
+		// Node events use the node name as UID, like the kubelet does.
+		InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: name, UID: types.UID(name)},
+		Reason:         ownershipConflictReason,
+		Message:        msg,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "virtual-kubelet", Host: self.Host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Error recording node ownership conflict event")
+	}
+}
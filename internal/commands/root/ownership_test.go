@@ -0,0 +1,219 @@
+package root
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newHeldLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	duration := int32(40)
+	renewTime := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: corev1.NamespaceNodeLease, Name: "node"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &duration,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+func getLeaseHolder(t *testing.T, client *fake.Clientset) string {
+	t.Helper()
+	l, err := client.CoordinationV1().Leases(corev1.NamespaceNodeLease).Get(context.Background(), "node", metav1.GetOptions{})
+	assert.NilError(t, err)
+	return leaseHolder(l)
+}
+
+func listEvents(t *testing.T, client *fake.Clientset) []corev1.Event {
+	t.Helper()
+	events, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	assert.NilError(t, err)
+	return events.Items
+}
+
+func TestClaimNode(t *testing.T) {
+	ctx := context.Background()
+	self := instance{ID: "self", Host: "host-a", StartTime: time.Now()}
+
+	t.Run("new node", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+		assert.NilError(t, claimNode(ctx, client, "node", self, true, false))
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-a_self"))
+		assert.Check(t, is.Len(listEvents(t, client), 0))
+	})
+
+	t.Run("existing node", func(t *testing.T) {
+		client := fake.NewSimpleClientset(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{"other": "annotation"}}},
+			newHeldLease("node", time.Now().Add(-time.Hour)),
+		)
+		assert.NilError(t, claimNode(ctx, client, "node", self, true, false))
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-a_self"))
+
+		n, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(n.Annotations["other"], "annotation"))
+		assert.Check(t, is.Equal(n.Annotations[instanceIDAnnotation], "self"))
+		assert.Check(t, is.Equal(n.Annotations[instanceHostAnnotation], "host-a"))
+	})
+
+	t.Run("held by another instance", func(t *testing.T) {
+		client := fake.NewSimpleClientset(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Annotations: map[string]string{
+				instanceIDAnnotation:   "other",
+				instanceHostAnnotation: "host-b",
+			}}},
+			newHeldLease("host-b_other", time.Now()),
+		)
+		err := claimNode(ctx, client, "node", self, true, false)
+		assert.Check(t, is.ErrorContains(err, "node node is held by instance other on host-b"))
+		assert.Check(t, is.ErrorContains(err, "--force-takeover"))
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-b_other"))
+
+		events := listEvents(t, client)
+		assert.Assert(t, is.Len(events, 1))
+		assert.Check(t, is.Equal(events[0].Reason, ownershipConflictReason))
+		assert.Check(t, is.Equal(events[0].Type, corev1.EventTypeWarning))
+		assert.Check(t, is.Equal(events[0].InvolvedObject.Kind, "Node"))
+		assert.Check(t, is.Equal(events[0].InvolvedObject.Name, "node"))
+
+		n, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(n.Annotations[instanceIDAnnotation], "other"))
+
+		// Without node leases there is nothing to check
+		assert.NilError(t, claimNode(ctx, client, "node", self, false, false))
+	})
+
+	t.Run("force takeover", func(t *testing.T) {
+		client := fake.NewSimpleClientset(newHeldLease("other", time.Now()))
+		assert.NilError(t, claimNode(ctx, client, "node", self, true, true))
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-a_self"))
+
+		events := listEvents(t, client)
+		assert.Assert(t, is.Len(events, 1))
+		assert.Check(t, is.Contains(events[0].Message, "took over node node from instance other"))
+	})
+
+	t.Run("expired lease", func(t *testing.T) {
+		client := fake.NewSimpleClientset(newHeldLease("other", time.Now().Add(-time.Minute)))
+		assert.NilError(t, claimNode(ctx, client, "node", self, true, false))
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-a_self"))
+		assert.Check(t, is.Len(listEvents(t, client), 0))
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		defer func(wait time.Duration) { leaseRenewWait = wait }(leaseRenewWait)
+		leaseRenewWait = 10 * time.Millisecond
+
+		client := fake.NewSimpleClientset(newHeldLease("host-a_crashed", time.Now()))
+		restarted := instance{ID: "restarted", Host: "host-a", StartTime: time.Now()}
+		assert.NilError(t, claimNode(ctx, client, "node", restarted, true, false))
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-a_restarted"))
+		assert.Check(t, is.Len(listEvents(t, client), 0))
+	})
+
+	t.Run("two instances on the same host", func(t *testing.T) {
+		defer func(wait time.Duration) { leaseRenewWait = wait }(leaseRenewWait)
+		leaseRenewWait = 50 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		client := fake.NewSimpleClientset()
+		first := instance{ID: "first", Host: "host-a", StartTime: time.Now()}
+		assert.NilError(t, claimNode(ctx, client, "node", first, true, false))
+
+		// The node controller of the first instance renews its lease
+		renewed := make(chan struct{})
+		go func() {
+			defer close(renewed)
+			leases := client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				l, err := leases.Get(ctx, "node", metav1.GetOptions{})
+				if err != nil {
+					continue
+				}
+				now := metav1.NewMicroTime(time.Now())
+				l.Spec.RenewTime = &now
+				leases.Update(ctx, l, metav1.UpdateOptions{}) //nolint:errcheck
+			}
+		}()
+
+		second := instance{ID: "second", Host: "host-a", StartTime: time.Now()}
+		err := claimNode(ctx, client, "node", second, true, false)
+		assert.Check(t, is.ErrorContains(err, "node node is held by instance host-a_first"))
+		cancel()
+		<-renewed
+
+		assert.Check(t, is.Equal(getLeaseHolder(t, client), "host-a_first"))
+		events := listEvents(t, client)
+		assert.Assert(t, is.Len(events, 1))
+		assert.Check(t, is.Equal(events[0].Reason, ownershipConflictReason))
+	})
+}
+
+func TestReleaseLease(t *testing.T) {
+	ctx := context.Background()
+
+	client := fake.NewSimpleClientset(newHeldLease("other", time.Now()))
+	assert.NilError(t, releaseLease(ctx, client, "node", instance{ID: "self"}))
+	assert.Check(t, is.Equal(getLeaseHolder(t, client), "other"))
+
+	assert.NilError(t, releaseLease(ctx, client, "node", instance{ID: "other"}))
+	assert.Check(t, is.Equal(getLeaseHolder(t, client), ""))
+
+	// A released lease does not block the next instance
+	assert.NilError(t, claimNode(ctx, client, "node", instance{ID: "next"}, true, false))
+	assert.Check(t, is.Equal(getLeaseHolder(t, client), "next"))
+}
+
+func TestWaitLeaseLost(t *testing.T) {
+	defer func(interval time.Duration) { leaseCheckInterval = interval }(leaseCheckInterval)
+	leaseCheckInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	self := instance{ID: "self", Host: "host-a"}
+	client := fake.NewSimpleClientset()
+	assert.NilError(t, claimNode(ctx, client, "node", self, true, false))
+
+	lost := make(chan error, 1)
+	go func() {
+		lost <- waitLeaseLost(ctx, client, "node", self)
+	}()
+	select {
+	case err := <-lost:
+		t.Fatalf("lease reported lost while held: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.NilError(t, claimNode(ctx, client, "node", instance{ID: "other", Host: "host-b"}, true, true))
+	select {
+	case err := <-lost:
+		assert.Check(t, is.ErrorContains(err, "node node was taken over by instance host-b_other"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost lease not detected")
+	}
+
+	// Returns without an error once cancelled
+	cancel()
+	assert.NilError(t, waitLeaseLost(ctx, client, "node", self))
+}
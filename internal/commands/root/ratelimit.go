@@ -0,0 +1,139 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// apiRateLimiter is the rate limiter used by the kubernetes client.
+// The qps and burst can be changed while the client is in use.
+type apiRateLimiter struct {
+	mu sync.RWMutex
+	l  flowcontrol.RateLimiter
+}
+
+func newAPIRateLimiter(qps, burst int32) *apiRateLimiter {
+	r := &apiRateLimiter{}
+	r.set(qps, burst)
+	return r
+}
+
+// set replaces the underlying rate limiter.
+// A value of 0 uses the kubernetes client default for qps or burst.
+func (r *apiRateLimiter) set(qps, burst int32) {
+	q := rest.DefaultQPS
+	if qps != 0 {
+		q = float32(qps)
+	}
+	b := rest.DefaultBurst
+	if burst != 0 {
+		b = int(burst)
+	}
+
+	r.mu.Lock()
+	old := r.l
+	r.l = flowcontrol.NewTokenBucketRateLimiter(q, b)
+	r.mu.Unlock()
+
+	if old != nil {
+		old.Stop()
+	}
+}
+
+func (r *apiRateLimiter) get() flowcontrol.RateLimiter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.l
+}
+
+func (r *apiRateLimiter) TryAccept() bool {
+	return r.get().TryAccept()
+}
+
+func (r *apiRateLimiter) Accept() {
+	r.get().Accept()
+}
+
+func (r *apiRateLimiter) Stop() {
+	r.get().Stop()
+}
+
+func (r *apiRateLimiter) QPS() float32 {
+	return r.get().QPS()
+}
+
+func (r *apiRateLimiter) Wait(ctx context.Context) error {
+	return r.get().Wait(ctx)
+}
+
+// queueRateLimiter is a workqueue rate limiter which can be replaced while
+// the queue is in use.
+// Replacing the rate limiter resets the back-off state of all items.
+type queueRateLimiter struct {
+	mu sync.RWMutex
+	l  workqueue.RateLimiter
+}
+
+func newQueueRateLimiter(l workqueue.RateLimiter) *queueRateLimiter {
+	r := &queueRateLimiter{}
+	r.set(l)
+	return r
+}
+
+// set replaces the underlying rate limiter.
+// A nil rate limiter uses the default controller rate limiter.
+//
+// A rate limiter created from a spec is created again, so the queues using
+// the same options do not share their limits. Any other rate limiter is
+// used as is, and is shared by all the queues it is set on.
+func (r *queueRateLimiter) set(l workqueue.RateLimiter) {
+	if l == nil {
+		l = workqueue.DefaultControllerRateLimiter()
+	} else if spec := opts.RateLimiterSpec(l); spec != "" {
+		if own, err := opts.ParseRateLimiter(spec); err == nil {
+			l = own
+		}
+	}
+
+	r.mu.Lock()
+	r.l = l
+	r.mu.Unlock()
+}
+
+func (r *queueRateLimiter) get() workqueue.RateLimiter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.l
+}
+
+func (r *queueRateLimiter) When(item interface{}) time.Duration {
+	return r.get().When(item)
+}
+
+func (r *queueRateLimiter) Forget(item interface{}) {
+	r.get().Forget(item)
+}
+
+func (r *queueRateLimiter) NumRequeues(item interface{}) int {
+	return r.get().NumRequeues(item)
+}
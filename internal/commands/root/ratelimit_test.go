@@ -0,0 +1,22 @@
+package root
+
+import (
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestQueueRateLimiterPerQueue(t *testing.T) {
+	l, err := opts.ParseRateLimiter("exponential:5ms-1s")
+	assert.NilError(t, err)
+
+	a, b := newQueueRateLimiter(l), newQueueRateLimiter(l)
+	a.When("pod")
+	a.When("pod")
+	assert.Check(t, is.Equal(a.NumRequeues("pod"), 2))
+	assert.Check(t, is.Equal(b.NumRequeues("pod"), 0))
+	assert.Check(t, is.Equal(l.NumRequeues("pod"), 0))
+	assert.Check(t, is.Equal(opts.RateLimiterSpec(b.get()), "exponential:5ms-1s"))
+}
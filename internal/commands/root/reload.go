@@ -0,0 +1,256 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+var configPollInterval = 10 * time.Second
+
+// reloadFunc applies a new set of options to a running component.
+type reloadFunc func(context.Context, *opts.Opts) error
+
+// reloader re-applies the options which are safe to change while running.
+//
+// Components register a reloadFunc along with the option fields they can
+// reload, any other change requires a restart.
+type reloader struct {
+	load     func(context.Context) (*opts.Opts, error)
+	onConfig []func(context.Context, *opts.Opts) error
+
+	// sig receives SIGHUP from when the reloader is created, so the signal
+	// does not kill the process while it starts.
+	sig chan os.Signal
+
+	mu       sync.Mutex
+	current  *opts.Opts
+	handlers []reloadHandler
+}
+
+type reloadHandler struct {
+	fields []string
+	f      reloadFunc
+}
+
+// newReloader creates a reloader for the passed in options.
+// load is used to resolve the new options, if it is nil reloading is disabled.
+// onConfig is called after every reload which changed something.
+//
+// SIGHUP is trapped from now on, `stop` must be called once the reloader is
+// no longer used.
+func newReloader(o *opts.Opts, load func(context.Context) (*opts.Opts, error), onConfig []func(context.Context, *opts.Opts) error) *reloader {
+	current := *o
+	r := &reloader{
+		load:     load,
+		onConfig: onConfig,
+		current:  &current,
+	}
+	if load != nil {
+		r.sig = make(chan os.Signal, 1)
+		signal.Notify(r.sig, syscall.SIGHUP)
+	}
+	r.register(func(ctx context.Context, o *opts.Opts) error {
+		return setLogLevel(ctx, o.LogLevel)
+	}, "LogLevel")
+	return r
+}
+
+// stop stops trapping SIGHUP.
+func (r *reloader) stop() {
+	if r.sig != nil {
+		signal.Stop(r.sig)
+	}
+}
+
+// register adds a function which is called when any of the given fields
+// changes.
+// Fields are named as returned by `opts.Diff`.
+func (r *reloader) register(f reloadFunc, fields ...string) {
+	r.mu.Lock()
+	r.handlers = append(r.handlers, reloadHandler{fields: fields, f: f})
+	r.mu.Unlock()
+}
+
+func (r *reloader) reloadable(field string) bool {
+	for _, h := range r.handlers {
+		for _, f := range h.fields {
+			if f == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// run reloads the options whenever SIGHUP is received or one of the config
+// files changes, until the context is cancelled.
+// A SIGHUP received before run is called triggers a reload once it runs.
+func (r *reloader) run(ctx context.Context) {
+	if r.load == nil {
+		return
+	}
+
+	configPaths := []string{r.current.KubeletConfigPath, r.current.ConfigPath}
+	lastConfig := readConfigs(ctx, configPaths)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.sig:
+			log.G(ctx).Info("Received SIGHUP, reloading options")
+		case <-ticker.C:
+			data := readConfigs(ctx, configPaths)
+			if data == nil || bytes.Equal(data, lastConfig) {
+				continue
+			}
+			lastConfig = data
+			log.G(ctx).Info("Config file changed, reloading options")
+		}
+
+		if err := r.reload(ctx); err != nil {
+			log.G(ctx).WithError(err).Error("Error reloading options")
+		}
+	}
+}
+
+// readConfigs returns the contents of all the config files, or nil if any
+// of them cannot be read.
+func readConfigs(ctx context.Context, paths []string) []byte {
+	var all []byte
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		data := readConfig(ctx, p)
+		if data == nil {
+			return nil
+		}
+		all = append(all, data...)
+	}
+	return all
+}
+
+func readConfig(ctx context.Context, p string) []byte {
+	if p == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("config", p).Warn("Could not read config file")
+		return nil
+	}
+	return data
+}
+
+// reload resolves the options again and applies all reloadable changes.
+//
+// If there are changes which cannot be applied without a restart an error
+// listing them is returned, the reloadable changes are applied regardless.
+func (r *reloader) reload(ctx context.Context) error {
+	next, err := r.load(ctx)
+	if err != nil {
+		return err
+	}
+	return r.apply(ctx, next)
+}
+
+func (r *reloader) apply(ctx context.Context, next *opts.Opts) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var (
+		changed = opts.Diff(r.current, next)
+		restart []string
+		applied = *r.current
+	)
+	for _, f := range changed {
+		if !r.reloadable(f) {
+			restart = append(restart, f)
+			continue
+		}
+		setField(&applied, next, f)
+	}
+
+	var errs []string
+	if len(restart) < len(changed) {
+		log.G(ctx).WithField("fields", changed).Debug("Applying reloaded options")
+		for _, h := range r.handlers {
+			if !anyChanged(h.fields, changed) {
+				continue
+			}
+			if err := h.f(ctx, &applied); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		for _, f := range r.onConfig {
+			if err := f(ctx, &applied); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		r.current = &applied
+	}
+
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		errs = append(errs, fmt.Sprintf("changes to %s require a restart", strings.Join(restart, ", ")))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func anyChanged(fields, changed []string) bool {
+	for _, f := range fields {
+		for _, c := range changed {
+			if f == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setField copies the field with the given path from src to dst.
+func setField(dst, src *opts.Opts, path string) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	for _, name := range strings.Split(path, ".") {
+		d = d.FieldByName(name)
+		s = s.FieldByName(name)
+	}
+	d.Set(s)
+}
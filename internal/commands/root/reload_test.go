@@ -0,0 +1,172 @@
+package root
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	logruslogger "github.com/virtual-kubelet/virtual-kubelet/log/logrus"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReloaderApply(t *testing.T) {
+	ctx := context.Background()
+
+	o := opts.New()
+	r := newReloader(o, nil, nil)
+
+	var reloaded *opts.Opts
+	r.register(func(_ context.Context, o *opts.Opts) error {
+		reloaded = o
+		return nil
+	}, "TaintKey")
+
+	t.Run("reloadable", func(t *testing.T) {
+		next := *o
+		next.TaintKey = "reloaded"
+		assert.NilError(t, r.apply(ctx, &next))
+		assert.Assert(t, reloaded != nil)
+		assert.Check(t, is.Equal(reloaded.TaintKey, "reloaded"))
+	})
+
+	t.Run("requires restart", func(t *testing.T) {
+		reloaded = nil
+
+		next := *o
+		next.TaintKey = "reloaded-again"
+		next.NodeName = "other"
+		next.PodSyncWorkers = 1
+
+		err := r.apply(ctx, &next)
+		assert.ErrorContains(t, err, "changes to NodeName, PodSyncWorkers require a restart")
+
+		// The reloadable change is still applied
+		assert.Assert(t, reloaded != nil)
+		assert.Check(t, is.Equal(reloaded.TaintKey, "reloaded-again"))
+		assert.Check(t, is.Equal(r.current.NodeName, o.NodeName))
+	})
+}
+
+func TestReloaderLogLevel(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.InfoLevel)
+	ctx := log.WithLogger(context.Background(), logruslogger.FromLogrus(logrus.NewEntry(logger)).WithField("node", "node"))
+
+	o := opts.New()
+	next := *o
+	next.LogLevel = "debug"
+
+	var logLevel string
+	r := newReloader(o, nil, []func(context.Context, *opts.Opts) error{
+		func(_ context.Context, o *opts.Opts) error {
+			logLevel = o.LogLevel
+			return nil
+		},
+	})
+	assert.NilError(t, r.apply(ctx, &next))
+	assert.Check(t, is.Equal(logger.GetLevel(), logrus.DebugLevel))
+	assert.Check(t, is.Equal(logLevel, "debug"))
+
+	next.LogLevel = "loud"
+	assert.ErrorContains(t, r.apply(ctx, &next), "error parsing log level")
+	assert.Check(t, is.Equal(logger.GetLevel(), logrus.DebugLevel))
+}
+
+func TestReloaderSIGHUPBeforeRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := opts.New()
+	loaded := make(chan struct{}, 1)
+	r := newReloader(o, func(context.Context) (*opts.Opts, error) {
+		loaded <- struct{}{}
+		return o, nil
+	}, nil)
+	defer r.stop()
+
+	// The signal is trapped while the node starts, and the options are
+	// reloaded once the reloader runs
+	assert.NilError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	go r.run(ctx)
+	select {
+	case <-loaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("options not reloaded")
+	}
+}
+
+func TestReloaderAuthWebhookDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := opts.New()
+	o.Provider = "mock"
+	o.Authentication.Webhook.Enabled = false
+	client := fake.NewSimpleClientset()
+	r := newReloader(o, nil, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runRootCommandWithProviderAndClient(ctx, newMockStore(), client, o, r, Extensions{})
+	}()
+
+	err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		select {
+		case err := <-errCh:
+			return false, err
+		default:
+		}
+		_, err := client.CoreV1().Nodes().Get(ctx, opts.DefaultNodeName, metav1.GetOptions{})
+		return err == nil, nil
+	})
+	assert.NilError(t, err)
+
+	next := *o
+	next.Authentication.Webhook.CacheTTL = metav1.Duration{Duration: time.Hour}
+	err = r.apply(ctx, &next)
+	assert.ErrorContains(t, err, "changes to Authentication.Webhook.CacheTTL require a restart")
+}
+
+func TestOptsLoaderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-reload")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	assert.NilError(t, ioutil.WriteFile(configPath, []byte("taintKey: first\nkubeAPIQPS: 10\n"), 0600))
+
+	o := opts.New()
+	defaults := *o
+	flags := pflag.NewFlagSet(t.Name(), pflag.ContinueOnError)
+	installFlags(flags, o)
+	assert.NilError(t, flags.Parse([]string{"--config", configPath, "--nodename", "from-flag", "--sync-pods-rate-limiter", "bucket:5qps/5",
+		"--address", "10.0.0.1", "--listen-addr", "10.0.0.2"}))
+	assert.NilError(t, resolveOpts(context.Background(), flags, o))
+	assert.Check(t, is.Equal(o.TaintKey, "first"))
+
+	assert.NilError(t, ioutil.WriteFile(configPath, []byte("taintKey: second\nnodeName: from-file\n"), 0600))
+
+	l := &optsLoader{defaults: defaults, flags: flags}
+	next, err := l.load(context.Background())
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Equal(next.TaintKey, "second"))
+	// Flags still take precedence
+	assert.Check(t, is.Equal(next.NodeName, "from-flag"))
+	assert.Check(t, is.Equal(opts.RateLimiterSpec(next.SyncPodsFromKubernetesRateLimiter), "bucket:5qps/5"))
+	assert.Check(t, is.DeepEqual(next.ListenAddresses, []string{"10.0.0.1", "10.0.0.2"}))
+	// Removed from the file, so back to the default
+	assert.Check(t, is.Equal(next.KubeAPIQPS, int32(0)))
+	assert.Check(t, is.DeepEqual(opts.Diff(o, next), []string{"TaintKey", "KubeAPIQPS"}))
+}
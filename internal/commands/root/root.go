@@ -17,35 +17,41 @@ package root
 import (
 	"context"
 	"os"
-	"path"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"github.com/virtual-kubelet/node-cli/manager"
+	"github.com/spf13/pflag"
 	"github.com/virtual-kubelet/node-cli/opts"
 	"github.com/virtual-kubelet/node-cli/provider"
-	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/node"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/apimachinery/pkg/types"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/kubernetes/typed/coordination/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
+// Extensions holds the hooks the embedding program can register with the root
+// command.
+type Extensions struct {
+	// ConfigCallbacks are called with the resolved options before the node is
+	// started, and again every time the options are reloaded.
+	ConfigCallbacks []func(context.Context, *opts.Opts) error
+	// NodeConditionChecks set node conditions on every node.
+	NodeConditionChecks []provider.NodeConditionCheck
+}
+
 // NewCommand creates a new top-level command.
 // This command is used to start the virtual-kubelet daemon
-func NewCommand(name string, s *provider.Store, o *opts.Opts) *cobra.Command {
+func NewCommand(name string, s *provider.Store, o *opts.Opts, ext Extensions) *cobra.Command {
+	var defaults opts.Opts
+
 	cmd := &cobra.Command{
 		Use:   name,
 		Short: name + " provides a virtual kubelet interface for your kubernetes cluster.",
@@ -53,12 +59,35 @@ func NewCommand(name string, s *provider.Store, o *opts.Opts) *cobra.Command {
 backend implementation allowing users to create kubernetes nodes without running the kubelet.
 This allows users to schedule kubernetes workloads on nodes that aren't running Kubernetes.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runRootCommand(cmd.Context(), s, o)
+			ctx := cmd.Context()
+			if err := resolveOpts(ctx, cmd.Flags(), o); err != nil {
+				return err
+			}
+			if err := setLogLevel(ctx, o.LogLevel); err != nil {
+				return err
+			}
+			for _, f := range ext.ConfigCallbacks {
+				if err := f(ctx, o); err != nil {
+					return err
+				}
+			}
+
+			l := &optsLoader{defaults: defaults, flags: cmd.Flags()}
+			r := newReloader(o, l.load, ext.ConfigCallbacks)
+			defer r.stop()
+			return runRootCommand(ctx, s, o, r, ext)
 		},
 	}
 
 	applyDefaults(o)
-	installFlags(cmd.Flags(), o)
+	defaults = *o
+	// The node flags are added to the subcommands which resolve the options
+	// the same way, e.g. validate-config, but not to the others.
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	installFlags(flags, o)
+	cmd.Flags().AddFlagSet(flags)
+
+	cmd.AddCommand(newValidateCommand(s, o, flags), newConfigCommand(o, flags))
 
 	return cmd
 }
@@ -67,201 +96,178 @@ func applyDefaults(o *opts.Opts) {
 	o.Authentication.Webhook.Enabled = false
 }
 
-func runRootCommand(ctx context.Context, s *provider.Store, c *opts.Opts) error {
-	pInit := s.Get(c.Provider)
-	if pInit == nil {
-		return errors.Errorf("provider %q not found", c.Provider)
-	}
-
-	client, err := newClient(c.KubeConfigPath, c.KubeAPIQPS, c.KubeAPIBurst)
+func runRootCommand(ctx context.Context, s *provider.Store, c *opts.Opts, r *reloader, ext Extensions) error {
+	apiRateLimiter := newAPIRateLimiter(c.KubeAPIQPS, c.KubeAPIBurst)
+	client, err := newClient(c.KubeConfigPath, c.MasterURI, apiRateLimiter)
 	if err != nil {
 		return err
 	}
+	r.register(func(_ context.Context, o *opts.Opts) error {
+		apiRateLimiter.set(o.KubeAPIQPS, o.KubeAPIBurst)
+		return nil
+	}, "KubeAPIQPS", "KubeAPIBurst")
 
-	return runRootCommandWithProviderAndClient(ctx, pInit, client, c)
+	return runRootCommandWithProviderAndClient(ctx, s, client, c, r, ext)
 }
 
-func runRootCommandWithProviderAndClient(ctx context.Context, pInit provider.InitFunc, client kubernetes.Interface, c *opts.Opts) error {
+func runRootCommandWithProviderAndClient(ctx context.Context, s *provider.Store, client kubernetes.Interface, c *opts.Opts, r *reloader, ext Extensions) error {
+	if err := c.Validate(s); err != nil {
+		return err
+	}
+	if err := validateNodeConditionChecks(ext.NodeConditionChecks); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	if ok := provider.ValidOperatingSystems[c.OperatingSystem]; !ok {
-		return errdefs.InvalidInputf("operating system %q is not supported", c.OperatingSystem)
-	}
+	specs := c.NodeSpecs()
 
-	if c.PodSyncWorkers == 0 {
-		return errdefs.InvalidInput("pod sync workers must be greater than 0")
+	// Make sure no other instance is running the nodes before starting.
+	// With leader election this is done once elected.
+	self := newInstance()
+	claimNodes := func(ctx context.Context, force bool) error {
+		for _, spec := range specs {
+			if err := claimNode(ctx, client, spec.Name, self, c.EnableNodeLease, force); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-
-	var taint *corev1.Taint
-	if !c.DisableTaint {
-		var err error
-		taint, err = getTaint(c)
-		if err != nil {
+	if !c.LeaderElect {
+		if err := claimNodes(ctx, c.ForceTakeover); err != nil {
 			return err
 		}
 	}
 
-	// Create a shared informer factory for Kubernetes pods in the current namespace (if specified) and scheduled to the current node.
-	podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
-		client,
-		c.InformerResyncPeriod,
-		kubeinformers.WithNamespace(c.KubeNamespace),
-		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
-			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", c.NodeName).String()
-		}))
-	podInformer := podInformerFactory.Core().V1().Pods()
-
-	// Create another shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
-	scmInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(client, c.InformerResyncPeriod)
-	// Create a secret informer and a config map informer so we can pass their listers to the resource manager.
-	secretInformer := scmInformerFactory.Core().V1().Secrets()
-	configMapInformer := scmInformerFactory.Core().V1().ConfigMaps()
-	serviceInformer := scmInformerFactory.Core().V1().Services()
-	pvcInformer := scmInformerFactory.Core().V1().PersistentVolumeClaims()
-	pvInformer := scmInformerFactory.Core().V1().PersistentVolumes()
-
-	rm, err := manager.NewResourceManager(podInformer.Lister(),
-		secretInformer.Lister(),
-		configMapInformer.Lister(),
-		serviceInformer.Lister(),
-		pvcInformer.Lister(),
-		pvInformer.Lister())
-	if err != nil {
-		return errors.Wrap(err, "could not create resource manager")
-	}
+	namespaces := c.WatchedNamespaces()
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("watchedNamespace", namespaces.String()))
 
-	// Start the informers now, so the provider will get a functional resource
-	// manager.
-	podInformerFactory.Start(ctx.Done())
-	scmInformerFactory.Start(ctx.Done())
+	// Create informers for Kubernetes secrets, configmaps and other resources in the watched namespaces (not subject to any selectors).
+	// They are shared by all the nodes, each node has its own pod informer.
+	scmInformerFactory := newNamespaceInformers(client, c.InformerResyncPeriod, namespaces, nil)
+	// Persistent volumes are not namespaced.
+	pvInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(client, c.InformerResyncPeriod)
+	pvs := pvInformerFactory.Core().V1().PersistentVolumes()
+	// Request the informer before the factory is started, it only starts
+	// the informers requested so far.
+	pvs.Informer()
 
-	apiConfig, err := getAPIConfig(c)
-	if err != nil {
-		return err
+	eb := record.NewBroadcaster()
+	eb.StartLogging(log.G(ctx).Infof)
+	eb.StartRecordingToSink(namespaceEventSink{
+		EventSink:  &corev1client.EventSinkImpl{Interface: client.CoreV1().Events(corev1.NamespaceAll)},
+		namespaces: namespaces,
+	})
+
+	shared := &sharedResources{
+		client:           client,
+		store:            s,
+		reloader:         r,
+		self:             self,
+		namespaces:       namespaces,
+		secrets:          scmInformerFactory.Secrets(),
+		configMaps:       scmInformerFactory.ConfigMaps(),
+		services:         scmInformerFactory.Services(),
+		pvcs:             scmInformerFactory.PersistentVolumeClaims(),
+		pvs:              pvs,
+		eventBroadcaster: eb,
+		conditionChecks:  ext.NodeConditionChecks,
 	}
 
-	if apiConfig.AuthWebhookEnabled {
-		// TODO(guwe): handle CA rotate?
-		auth, _, err := BuildAuth(types.NodeName(c.NodeName), client, *c)
+	// Start the informers now, so the providers will get a functional
+	// resource manager.
+	scmInformerFactory.Start(ctx.Done())
+	pvInformerFactory.Start(ctx.Done())
+
+	nodes := make([]*virtualNode, 0, len(specs))
+	for _, spec := range specs {
+		n, err := newVirtualNode(ctx, shared, c, spec)
 		if err != nil {
 			return err
 		}
-		apiConfig.Auth = auth
-	}
-
-	initConfig := provider.InitConfig{
-		ConfigPath:        c.ProviderConfigPath,
-		NodeName:          c.NodeName,
-		OperatingSystem:   c.OperatingSystem,
-		ResourceManager:   rm,
-		DaemonPort:        int32(c.ListenPort),
-		InternalIP:        os.Getenv("VKUBELET_POD_IP"),
-		KubeClusterDomain: c.KubeClusterDomain,
+		defer n.close()
+		nodes = append(nodes, n)
 	}
 
-	p, err := pInit(initConfig)
-	if err != nil {
-		return errors.Wrapf(err, "error initializing provider %s", c.Provider)
+	// The health server is up on standby instances too.
+	if c.HealthAddr != "" {
+		livez, readyz := healthChecks(nodes, informerSyncChecks(shared, nodes))
+		hs, err := setupHealthServer(ctx, c.HealthAddr, livez, readyz)
+		if err != nil {
+			return err
+		}
+		defer hs.Close()
 	}
 
-	ctx = log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{
-		"provider":         c.Provider,
-		"operatingSystem":  c.OperatingSystem,
-		"node":             c.NodeName,
-		"watchedNamespace": c.KubeNamespace,
-	}))
-
-	nodeProvider, ok := p.(node.NodeProvider)
-	if !ok {
-		nodeProvider = node.NaiveNodeProvider{}
-	}
-	pNode := NodeFromProvider(ctx, c.NodeName, taint, p, c.Version)
-	opts := []node.NodeControllerOpt{
-		node.WithNodeStatusUpdateErrorHandler(func(ctx context.Context, err error) error {
-			if !k8serrors.IsNotFound(err) {
-				return err
-			}
+	// runNodes runs all the nodes until ctx is cancelled or one of them
+	// fails, which stops the others.
+	// The `--on-shutdown` mode is only applied when stopping, not when
+	// leadership is lost.
+	stopping := ctx.Done()
+	runNodes := func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		errs := make(chan error, len(nodes))
+		for _, n := range nodes {
+			go func(n *virtualNode) {
+				err := n.run(ctx, stopping)
+				if err != nil {
+					cancel()
+				}
+				errs <- err
+			}(n)
+		}
 
-			log.G(ctx).Debug("node not found")
-			newNode := pNode.DeepCopy()
-			newNode.ResourceVersion = ""
-			_, err = client.CoreV1().Nodes().Create(ctx, newNode, metav1.CreateOptions{})
-			if err != nil {
-				return err
+		var err error
+		for range nodes {
+			if nerr := <-errs; nerr != nil && err == nil {
+				err = nerr
 			}
-			log.G(ctx).Debug("created new node")
-			return nil
-		}),
-	}
-	var leaseClient v1.LeaseInterface
-	if c.EnableNodeLease {
-		leaseClient = client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
-		opts = append(opts, node.WithNodeEnableLeaseV1(leaseClient, node.DefaultLeaseDuration))
+		}
+		return err
 	}
 
-	nodeRunner, err := node.NewNodeController(
-		nodeProvider,
-		pNode,
-		client.CoreV1().Nodes(),
-		opts...,
-	)
-	if err != nil {
-		log.G(ctx).Fatal(err)
-	}
+	go r.run(ctx)
 
-	eb := record.NewBroadcaster()
-	eb.StartLogging(log.G(ctx).Infof)
-	eb.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: client.CoreV1().Events(c.KubeNamespace)})
-
-	pc, err := node.NewPodController(node.PodControllerConfig{
-		PodClient:                            client.CoreV1(),
-		PodInformer:                          podInformer,
-		EventRecorder:                        eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: path.Join(pNode.Name, "pod-controller")}),
-		Provider:                             p,
-		SecretInformer:                       secretInformer,
-		ConfigMapInformer:                    configMapInformer,
-		ServiceInformer:                      serviceInformer,
-		SyncPodsFromKubernetesRateLimiter:    c.SyncPodsFromKubernetesRateLimiter,
-		DeletePodsFromKubernetesRateLimiter:  c.DeletePodsFromKubernetesRateLimiter,
-		SyncPodStatusFromProviderRateLimiter: c.SyncPodStatusFromProviderRateLimiter,
-	})
-	if err != nil {
-		return errors.Wrap(err, "error setting up pod controller")
+	if !c.LeaderElect {
+		return runNodes(ctx)
 	}
-
-	cancelHTTP, err := setupHTTPServer(ctx, p, apiConfig)
-	if err != nil {
+	return runWithLeaderElection(ctx, client, c, self.ID, func(leaderCtx context.Context) error {
+		// Another instance has been elected to run the nodes, they must not
+		// be claimed by this one anymore.
+		if err := claimNodes(leaderCtx, true); err != nil {
+			return err
+		}
+		err := runNodes(leaderCtx)
+		if err == nil && ctx.Err() == nil {
+			err = errors.New("lost leadership")
+		}
 		return err
-	}
-	defer cancelHTTP()
+	})
+}
 
-	go func() {
-		if err := pc.Run(ctx, c.PodSyncWorkers); err != nil && errors.Cause(err) != context.Canceled {
-			log.G(ctx).Fatal(err)
-		}
-	}()
+// shutdown releases the node lease and applies the mode, one of the
+// `--on-shutdown` modes, once ctx is cancelled, waiting for the node
+// controller to stop first so it does not undo it.
+// It takes at most `ShutdownTimeout`.
+func shutdown(ctx context.Context, client kubernetes.Interface, nodeRunner *node.NodeController, self instance, c *opts.Opts, mode string) error {
+	ctx, cancel := context.WithTimeout(log.WithLogger(context.Background(), log.G(ctx)), c.ShutdownTimeout)
+	defer cancel()
 
-	if c.StartupTimeout > 0 {
-		// If there is a startup timeout, it does two things:
-		// 1. It causes the VK to shutdown if we haven't gotten into an operational state in a time period
-		// 2. It prevents node advertisement from happening until we're in an operational state
-		err = waitFor(ctx, c.StartupTimeout, pc.Ready())
-		if err != nil {
-			return err
-		}
+	select {
+	case <-nodeRunner.Done():
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "timed out waiting for the node controller to stop")
 	}
 
-	go func() {
-		if err := nodeRunner.Run(ctx); err != nil {
-			log.G(ctx).Fatal(err)
+	if c.EnableNodeLease {
+		if err := releaseLease(ctx, client, c.NodeName, self); err != nil && !k8serrors.IsNotFound(err) {
+			log.G(ctx).WithError(err).Warn("Error releasing node lease")
 		}
-	}()
-
-	log.G(ctx).Info("Initialized")
-
-	<-ctx.Done()
-	return nil
+	}
+	return shutdownNode(ctx, client, c.NodeName, mode)
 }
 
 func waitFor(ctx context.Context, time time.Duration, ready <-chan struct{}) error {
@@ -279,7 +285,7 @@ func waitFor(ctx context.Context, time time.Duration, ready <-chan struct{}) err
 	}
 }
 
-func newClient(configPath string, qps, burst int32) (*kubernetes.Clientset, error) {
+func newClient(configPath, masterURI string, rateLimiter flowcontrol.RateLimiter) (*kubernetes.Clientset, error) {
 	var config *rest.Config
 
 	// Check if the kubeConfig file exists.
@@ -297,15 +303,9 @@ func newClient(configPath string, qps, burst int32) (*kubernetes.Clientset, erro
 		}
 	}
 
-	if qps != 0 {
-		config.QPS = float32(qps)
-	}
-
-	if burst != 0 {
-		config.Burst = int(burst)
-	}
+	config.RateLimiter = rateLimiter
 
-	if masterURI := os.Getenv("MASTER_URI"); masterURI != "" {
+	if masterURI != "" {
 		config.Host = masterURI
 	}
 
@@ -2,33 +2,45 @@ package root
 
 import (
 	"context"
+	"net"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/virtual-kubelet/node-cli/opts"
 	"github.com/virtual-kubelet/node-cli/provider"
 	"github.com/virtual-kubelet/node-cli/provider/mock"
 	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
-func TestRunRootCommand(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	opts := opts.New()
-	providerInitFunc := func(cfg provider.InitConfig) (provider.Provider, error) {
+// newMockStore returns a store with the mock provider registered as "mock".
+func newMockStore() *provider.Store {
+	s := provider.NewStore()
+	s.Register("mock", func(cfg provider.InitConfig) (provider.Provider, error) {
 		mockConfig := mock.Config{
 			CPU:    "1",
 			Memory: "128M",
 			Pods:   "120",
 		}
 		return mock.NewProviderConfig(mockConfig, cfg.NodeName, cfg.OperatingSystem, cfg.InternalIP, cfg.DaemonPort)
-	}
+	})
+	return s
+}
+
+func TestRunRootCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := opts.New()
+	opts.Provider = "mock"
 	fakeClient := fake.NewSimpleClientset()
 	errCh := make(chan error)
 	go func() {
-		errCh <- runRootCommandWithProviderAndClient(ctx, providerInitFunc, fakeClient, opts)
+		errCh <- runRootCommandWithProviderAndClient(ctx, newMockStore(), fakeClient, opts, newReloader(opts, nil, nil), Extensions{})
 	}()
 
 	watch, err := fakeClient.CoreV1().Nodes().Watch(ctx, metav1.ListOptions{})
@@ -40,3 +52,176 @@ func TestRunRootCommand(t *testing.T) {
 		break
 	}
 }
+
+func TestRunRootCommandValidates(t *testing.T) {
+	o := opts.New()
+	o.Provider = "other"
+	err := runRootCommandWithProviderAndClient(context.Background(), newMockStore(), fake.NewSimpleClientset(), o, newReloader(o, nil, nil), Extensions{})
+	assert.ErrorContains(t, err, `provider "other" not found`)
+}
+
+func TestRunRootCommandHealth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	o := opts.New()
+	o.Provider = "mock"
+	o.HealthAddr = addr
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runRootCommandWithProviderAndClient(ctx, newMockStore(), fake.NewSimpleClientset(), o, newReloader(o, nil, nil), Extensions{})
+	}()
+
+	get := func(path string) (int, error) {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		select {
+		case err := <-errCh:
+			return false, err
+		default:
+		}
+		code, err := get("/readyz")
+		return err == nil && code == http.StatusOK, nil
+	})
+	assert.NilError(t, err)
+
+	code, err := get("/healthz")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(code, http.StatusOK))
+}
+
+func TestRunRootCommandLeaderElection(t *testing.T) {
+	newOpts := func() *opts.Opts {
+		o := opts.New()
+		o.Provider = "mock"
+		o.LeaderElect = true
+		o.LeaderElectLeaseDuration = 2 * time.Second
+		o.LeaderElectRenewDeadline = time.Second
+		o.LeaderElectRetryPeriod = 100 * time.Millisecond
+		return o
+	}
+	client := fake.NewSimpleClientset()
+
+	start := func() (context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		o := newOpts()
+		go func() {
+			errCh <- runRootCommandWithProviderAndClient(ctx, newMockStore(), client, o, newReloader(o, nil, nil), Extensions{})
+		}()
+		return cancel, errCh
+	}
+
+	// nodeOwner returns the instance running the node once it is also the
+	// leader.
+	namespace, name := newOpts().LeaderElectionLease()
+	nodeOwner := func() string {
+		var owner string
+		err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+			n, err := client.CoreV1().Nodes().Get(context.Background(), opts.DefaultNodeName, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			l, err := client.CoordinationV1().Leases(namespace).Get(context.Background(), name, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			owner = n.Annotations[instanceIDAnnotation]
+			return owner != "" && owner == leaseHolder(l), nil
+		})
+		assert.NilError(t, err)
+		return owner
+	}
+
+	cancelA, errA := start()
+	defer cancelA()
+	leader := nodeOwner()
+
+	cancelB, errB := start()
+	defer cancelB()
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, nodeOwner(), leader, "standby took over from a running leader")
+
+	cancelA()
+	select {
+	case err := <-errA:
+		assert.NilError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("leader did not stop")
+	}
+
+	var newLeader string
+	err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+		newLeader = nodeOwner()
+		return newLeader != leader, nil
+	})
+	assert.NilError(t, err, "standby did not take over")
+
+	cancelB()
+	select {
+	case err := <-errB:
+		assert.NilError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("new leader did not stop")
+	}
+}
+
+func TestRunRootCommandMultipleNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := opts.New()
+	o.Provider = "mock"
+	o.NodeLabels = []string{"shared=label"}
+	o.Nodes = []opts.NodeSpec{
+		{Name: "node-a", NodeLabels: []string{"region=a"}},
+		{Name: "node-b", NodeLabels: []string{"region=b"}, RegisterWithTaints: []string{"pool=b:NoSchedule"}},
+	}
+	client := fake.NewSimpleClientset()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- runRootCommandWithProviderAndClient(ctx, newMockStore(), client, o, newReloader(o, nil, nil), Extensions{})
+	}()
+
+	getNode := func(name string) *corev1.Node {
+		var n *corev1.Node
+		err := wait.PollImmediate(10*time.Millisecond, 10*time.Second, func() (bool, error) {
+			var err error
+			n, err = client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			return err == nil, nil
+		})
+		assert.NilError(t, err, "node %s not registered", name)
+		return n
+	}
+
+	a := getNode("node-a")
+	assert.Check(t, is.Equal(a.Labels["shared"], "label"))
+	assert.Check(t, is.Equal(a.Labels["region"], "a"))
+	assert.Check(t, is.Equal(a.Status.DaemonEndpoints.KubeletEndpoint.Port, o.ListenPort))
+	assert.Check(t, is.Len(a.Spec.Taints, 1))
+
+	b := getNode("node-b")
+	assert.Check(t, is.Equal(b.Labels["shared"], "label"))
+	assert.Check(t, is.Equal(b.Labels["region"], "b"))
+	assert.Check(t, is.Equal(b.Status.DaemonEndpoints.KubeletEndpoint.Port, o.ListenPort+1))
+	assert.Check(t, is.Len(b.Spec.Taints, 2))
+
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NilError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("nodes did not stop")
+	}
+}
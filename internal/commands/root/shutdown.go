@@ -0,0 +1,123 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// shutdownTaintKey is the key of the NoExecute taint added to the node
+	// when it is cordoned on shutdown.
+	shutdownTaintKey = "virtual-kubelet.io/shutdown"
+	// shutdownCordonAnnotation marks a node cordoned on shutdown, so it can
+	// be uncordoned when virtual-kubelet starts again.
+	// The value records whether the node was made unschedulable, rather
+	// than being unschedulable already.
+	shutdownCordonAnnotation = "virtual-kubelet.io/cordoned-on-shutdown"
+)
+
+var shutdownTaint = corev1.Taint{Key: shutdownTaintKey, Effect: corev1.TaintEffectNoExecute}
+
+// shutdownNode applies the `--on-shutdown` mode to the node.
+func shutdownNode(ctx context.Context, client kubernetes.Interface, name, mode string) error {
+	ctx = log.WithLogger(ctx, log.G(ctx).WithField("onShutdown", mode))
+
+	switch mode {
+	case opts.ShutdownLeave:
+		return nil
+	case opts.ShutdownDelete:
+		err := client.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrap(err, "error deleting node")
+		}
+		err = client.CoordinationV1().Leases(corev1.NamespaceNodeLease).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrap(err, "error deleting node lease")
+		}
+		log.G(ctx).Info("Deleted node")
+		return nil
+	case opts.ShutdownCordon:
+		if err := cordonNode(ctx, client.CoreV1().Nodes(), name); err != nil {
+			return errors.Wrap(err, "error cordoning node")
+		}
+		log.G(ctx).Info("Cordoned node")
+		return nil
+	default:
+		return errdefs.InvalidInputf("on shutdown %q is not supported", mode)
+	}
+}
+
+// cordonNode marks the node unschedulable and adds the shutdown taint, so
+// the pods on the node are evicted.
+func cordonNode(ctx context.Context, nodes corev1client.NodeInterface, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := nodes.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if _, ok := n.Annotations[shutdownCordonAnnotation]; !ok {
+			if n.Annotations == nil {
+				n.Annotations = make(map[string]string, 1)
+			}
+			n.Annotations[shutdownCordonAnnotation] = strconv.FormatBool(!n.Spec.Unschedulable)
+		}
+		n.Spec.Unschedulable = true
+		n.Spec.Taints = replaceTaint(n.Spec.Taints, &shutdownTaint, &shutdownTaint)
+
+		_, err = nodes.Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// uncordonNode reverts `cordonNode`, if the node was cordoned on shutdown.
+// The node is left unschedulable if it already was when it was cordoned.
+func uncordonNode(ctx context.Context, nodes corev1client.NodeInterface, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := nodes.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		v, ok := n.Annotations[shutdownCordonAnnotation]
+		if !ok {
+			return nil
+		}
+		if made, _ := strconv.ParseBool(v); made {
+			n.Spec.Unschedulable = false
+		}
+		delete(n.Annotations, shutdownCordonAnnotation)
+		n.Spec.Taints = replaceTaint(n.Spec.Taints, &shutdownTaint, nil)
+
+		_, err = nodes.Update(ctx, n, metav1.UpdateOptions{})
+		if err == nil {
+			log.G(ctx).Info("Uncordoned node cordoned on shutdown")
+		}
+		return err
+	})
+}
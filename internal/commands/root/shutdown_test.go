@@ -0,0 +1,90 @@
+package root
+
+import (
+	"context"
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/opts"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newShutdownTestClient(unschedulable bool) *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+			Spec: corev1.NodeSpec{
+				Unschedulable: unschedulable,
+				Taints:        []corev1.Taint{{Key: "other", Effect: corev1.TaintEffectNoSchedule}},
+			},
+		},
+		&coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: corev1.NamespaceNodeLease, Name: "node"}},
+	)
+}
+
+func TestShutdownNodeLeave(t *testing.T) {
+	ctx := context.Background()
+	client := newShutdownTestClient(false)
+
+	assert.NilError(t, shutdownNode(ctx, client, "node", opts.ShutdownLeave))
+	_, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	assert.NilError(t, err)
+}
+
+func TestShutdownNodeDelete(t *testing.T) {
+	ctx := context.Background()
+	client := newShutdownTestClient(false)
+
+	assert.NilError(t, shutdownNode(ctx, client, "node", opts.ShutdownDelete))
+	_, err := client.CoreV1().Nodes().Get(ctx, "node", metav1.GetOptions{})
+	assert.Check(t, k8serrors.IsNotFound(err), err)
+	_, err = client.CoordinationV1().Leases(corev1.NamespaceNodeLease).Get(ctx, "node", metav1.GetOptions{})
+	assert.Check(t, k8serrors.IsNotFound(err), err)
+
+	// Nothing left to delete is fine
+	assert.NilError(t, shutdownNode(ctx, client, "node", opts.ShutdownDelete))
+}
+
+func TestShutdownNodeCordon(t *testing.T) {
+	for _, unschedulable := range []bool{false, true} {
+		ctx := context.Background()
+		client := newShutdownTestClient(unschedulable)
+		nodes := client.CoreV1().Nodes()
+
+		assert.NilError(t, shutdownNode(ctx, client, "node", opts.ShutdownCordon))
+		// Cordoning twice changes nothing
+		assert.NilError(t, shutdownNode(ctx, client, "node", opts.ShutdownCordon))
+
+		n, err := nodes.Get(ctx, "node", metav1.GetOptions{})
+		assert.NilError(t, err)
+		assert.Check(t, n.Spec.Unschedulable)
+		assert.Check(t, is.DeepEqual(n.Spec.Taints, []corev1.Taint{
+			{Key: "other", Effect: corev1.TaintEffectNoSchedule},
+			{Key: shutdownTaintKey, Effect: corev1.TaintEffectNoExecute},
+		}))
+
+		assert.NilError(t, uncordonNode(ctx, nodes, "node"))
+		n, err = nodes.Get(ctx, "node", metav1.GetOptions{})
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(n.Spec.Unschedulable, unschedulable))
+		assert.Check(t, is.DeepEqual(n.Spec.Taints, []corev1.Taint{{Key: "other", Effect: corev1.TaintEffectNoSchedule}}))
+		assert.Check(t, is.Len(n.Annotations, 0))
+	}
+}
+
+func TestUncordonNodeNotCordoned(t *testing.T) {
+	ctx := context.Background()
+	client := newShutdownTestClient(true)
+	nodes := client.CoreV1().Nodes()
+
+	assert.NilError(t, uncordonNode(ctx, nodes, "node"))
+	n, err := nodes.Get(ctx, "node", metav1.GetOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, n.Spec.Unschedulable)
+	assert.Check(t, is.Len(n.Spec.Taints, 1))
+}
@@ -0,0 +1,63 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// newValidateCommand creates the validate-config subcommand.
+// The options are resolved exactly like the root command does, from the
+// flags shared with the root command, the environment and the config file.
+func newValidateCommand(s *provider.Store, o *opts.Opts, flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate-config",
+		Short: "Validate the configuration without starting the node",
+		Long: `Resolve the configuration from flags, environment variables and the config
+file and report all problems found.
+This does not contact the Kubernetes API server.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveOpts(cmd.Context(), cmd.Flags(), o); err != nil {
+				return err
+			}
+
+			err := o.Validate(s)
+			if err == nil {
+				fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+				return nil
+			}
+
+			agg, ok := err.(utilerrors.Aggregate)
+			if !ok {
+				return err
+			}
+			for _, e := range agg.Errors() {
+				fmt.Fprintln(cmd.ErrOrStderr(), e)
+			}
+			return errors.Errorf("configuration is invalid: found %d problem(s)", len(agg.Errors()))
+		},
+	}
+	cmd.Flags().AddFlagSet(flags)
+	return cmd
+}
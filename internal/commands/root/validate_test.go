@@ -0,0 +1,52 @@
+package root
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestValidateCommand(t *testing.T) {
+	s := provider.NewStore()
+	s.Register("mock", nil)
+
+	run := func(args ...string) (string, string, error) {
+		cmd := NewCommand("vk", s, opts.New(), Extensions{})
+		var stdout, stderr bytes.Buffer
+		cmd.SetOut(&stdout)
+		cmd.SetErr(&stderr)
+		cmd.SetArgs(append([]string{"validate-config"}, args...))
+		err := cmd.Execute()
+		return stdout.String(), stderr.String(), err
+	}
+
+	stdout, _, err := run("--provider", "mock")
+	assert.NilError(t, err)
+	assert.Check(t, is.Contains(stdout, "configuration is valid"))
+
+	_, stderr, err := run("--provider", "other", "--pod-sync-workers", "0")
+	assert.ErrorContains(t, err, "found 2 problem(s)")
+	assert.Check(t, is.Contains(stderr, `provider "other" not found`))
+	assert.Check(t, is.Contains(stderr, "pod sync workers must be greater than 0"))
+}
+
+func TestNodeFlagsNotInherited(t *testing.T) {
+	cmd := NewCommand("vk", provider.NewStore(), opts.New(), Extensions{})
+	other := &cobra.Command{Use: "other"}
+	cmd.AddCommand(other)
+
+	assert.Check(t, cmd.Flags().Lookup("nodename") != nil)
+	assert.Check(t, is.Nil(other.Flags().Lookup("nodename")))
+	assert.Check(t, is.Nil(other.InheritedFlags().Lookup("nodename")))
+
+	for _, path := range [][]string{{"validate-config"}, {"config", "view"}} {
+		sub, _, err := cmd.Find(path)
+		assert.NilError(t, err)
+		assert.Check(t, sub.Flags().Lookup("nodename") != nil, path)
+	}
+}
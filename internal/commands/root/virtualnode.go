@@ -0,0 +1,479 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/node-cli/manager"
+	"github.com/virtual-kubelet/node-cli/opts"
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	v1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// sharedResources are the resources shared by all the nodes run by the
+// process.
+type sharedResources struct {
+	client   kubernetes.Interface
+	store    *provider.Store
+	reloader *reloader
+	self     instance
+
+	namespaces opts.NamespaceSet
+	secrets    corev1informers.SecretInformer
+	configMaps corev1informers.ConfigMapInformer
+	services   corev1informers.ServiceInformer
+	pvcs       corev1informers.PersistentVolumeClaimInformer
+	pvs        corev1informers.PersistentVolumeInformer
+
+	eventBroadcaster record.EventBroadcaster
+
+	conditionChecks []provider.NodeConditionCheck
+}
+
+// virtualNode is one of the nodes run by the process, with its own pod
+// informer, resource manager, provider and kubelet API server.
+type virtualNode struct {
+	c      *opts.Opts
+	spec   opts.NodeSpec
+	shared *sharedResources
+
+	podInformer  corev1informers.PodInformer
+	provider     provider.Provider
+	nodeProvider node.NodeProvider
+	logFields    log.Fields
+	auth         *reloadableAuth
+	cancelHTTP   func()
+	// certs are the serving certificates of the kubelet API, nil when it
+	// is not served.
+	certs *servingCerts
+	// certRequester gets the serving certificate of the kubelet API when
+	// there is no certificate file to serve until it is issued.
+	certRequester *certificateRequester
+	// extendedResources are the extended resources pods are admitted
+	// against, and which are added to every node status.
+	extendedResources corev1.ResourceList
+	// reserved are the resources subtracted from the allocatable resources
+	// of every node status.
+	reserved corev1.ResourceList
+	// The rate limiters of the pod controller queues, every node has its
+	// own.
+	syncPodsRateLimiter      *queueRateLimiter
+	deletePodsRateLimiter    *queueRateLimiter
+	syncPodStatusRateLimiter *queueRateLimiter
+
+	// mu guards replacing pNode and taint when the node taint is reloaded.
+	mu    sync.Mutex
+	pNode *corev1.Node
+	taint *corev1.Taint
+	// running is set while the node is registered by this process, the
+	// node must not be registered by a standby instance.
+	running bool
+	// runState is the state of the controllers while the node is run by
+	// this instance, nil otherwise.
+	runState *nodeHealth
+}
+
+// newVirtualNode sets up the node described by spec, c holds the top level
+// options. The kubelet API server of the node is started, it is stopped by
+// `close`.
+func newVirtualNode(ctx context.Context, shared *sharedResources, c *opts.Opts, spec opts.NodeSpec) (_ *virtualNode, retErr error) {
+	n := &virtualNode{
+		c:      c.ForNode(spec),
+		spec:   spec,
+		shared: shared,
+	}
+	defer func() {
+		if retErr != nil && n.auth != nil {
+			n.auth.close()
+		}
+	}()
+	c = n.c
+	client := shared.client
+
+	if !c.DisableTaint {
+		var err error
+		n.taint, err = getTaint(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reg, err := nodeRegistrationFromOpts(c)
+	if err != nil {
+		return nil, err
+	}
+	n.extendedResources = reg.ExtendedResources
+	n.reserved = reg.Reserved
+
+	pInit := shared.store.Get(c.Provider)
+	if pInit == nil {
+		return nil, errors.Errorf("provider %q not found", c.Provider)
+	}
+
+	// Create an informer for the pods in the watched namespaces scheduled to
+	// this node.
+	podInformerFactory := newNamespaceInformers(client, c.InformerResyncPeriod, shared.namespaces,
+		fields.OneTermEqualSelector("spec.nodeName", c.NodeName))
+	n.podInformer = podInformerFactory.Pods()
+
+	rm, err := manager.NewResourceManager(n.podInformer.Lister(),
+		shared.secrets.Lister(),
+		shared.configMaps.Lister(),
+		shared.services.Lister(),
+		shared.pvcs.Lister(),
+		shared.pvs.Lister())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create resource manager")
+	}
+
+	// Start the informer now, so the provider will get a functional
+	// resource manager.
+	podInformerFactory.Start(ctx.Done())
+
+	apiConfig, err := getAPIConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiConfig.AuthWebhookEnabled {
+		// The client CA of the authenticator is reloaded when its file
+		// changes, like the one of the TLS config.
+		auth, runCAReload, err := BuildAuth(types.NodeName(c.NodeName), client, *c)
+		if err != nil {
+			return nil, err
+		}
+		n.auth = &reloadableAuth{}
+		n.auth.set(auth, runCAReload)
+		apiConfig.Auth = n.auth
+
+		// The cache TTLs are only used by the webhook, without it changing
+		// them requires a restart like enabling the webhook does.
+		shared.reloader.register(func(_ context.Context, o *opts.Opts) error {
+			o = o.ForNode(spec)
+			auth, runCAReload, err := BuildAuth(types.NodeName(o.NodeName), client, *o)
+			if err != nil {
+				return err
+			}
+			n.auth.set(auth, runCAReload)
+			return nil
+		}, "Authentication.Webhook.CacheTTL", "Authorization.Webhook.CacheAuthorizedTTL", "Authorization.Webhook.CacheUnauthorizedTTL")
+	}
+
+	initConfig := provider.InitConfig{
+		ConfigPath:        c.ProviderConfigPath,
+		NodeName:          c.NodeName,
+		OperatingSystem:   c.OperatingSystem,
+		ResourceManager:   rm,
+		DaemonPort:        daemonPort(apiConfig.Addrs),
+		InternalIP:        os.Getenv("VKUBELET_POD_IP"),
+		KubeClusterDomain: c.KubeClusterDomain,
+	}
+
+	n.provider, err = pInit(initConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error initializing provider %s", c.Provider)
+	}
+
+	n.logFields = log.Fields{
+		"provider":        c.Provider,
+		"operatingSystem": c.OperatingSystem,
+		"node":            c.NodeName,
+	}
+	ctx = n.withLogger(ctx)
+
+	var ok bool
+	n.nodeProvider, ok = n.provider.(node.NodeProvider)
+	if !ok {
+		n.nodeProvider = node.NaiveNodeProvider{}
+	}
+	n.pNode, err = NodeFromProvider(ctx, c.NodeName, n.taint, n.provider, c.Version, reg)
+	if err != nil {
+		return nil, err
+	}
+	if n.pNode.Annotations == nil {
+		n.pNode.Annotations = make(map[string]string, 3)
+	}
+	for k, v := range shared.self.annotations() {
+		n.pNode.Annotations[k] = v
+	}
+	// The API server publishes the port it actually listens on, whatever
+	// the provider set.
+	n.pNode.Status.DaemonEndpoints.KubeletEndpoint.Port = daemonPort(apiConfig.Addrs)
+	apiConfig.NodeAddresses = n.pNode.Status.Addresses
+
+	if c.ServerTLSBootstrap {
+		apiConfig.CertRequester = newCertificateRequester(client.CertificatesV1().CertificateSigningRequests(), c.NodeName, n.pNode.Status.Addresses)
+		if c.TLSCertFile == "" && podServerDisabledReason(apiConfig) == "" {
+			n.certRequester = apiConfig.CertRequester
+		}
+	}
+
+	shared.reloader.register(func(ctx context.Context, o *opts.Opts) error {
+		return n.reloadTaint(n.withLogger(ctx), o.ForNode(spec))
+	}, "TaintKey", "TaintValue", "TaintEffect", "DisableTaint")
+
+	n.syncPodsRateLimiter = newQueueRateLimiter(c.SyncPodsFromKubernetesRateLimiter)
+	n.deletePodsRateLimiter = newQueueRateLimiter(c.DeletePodsFromKubernetesRateLimiter)
+	n.syncPodStatusRateLimiter = newQueueRateLimiter(c.SyncPodStatusFromProviderRateLimiter)
+	shared.reloader.register(func(_ context.Context, o *opts.Opts) error {
+		n.syncPodsRateLimiter.set(o.SyncPodsFromKubernetesRateLimiter)
+		return nil
+	}, "SyncPodsFromKubernetesRateLimiter")
+	shared.reloader.register(func(_ context.Context, o *opts.Opts) error {
+		n.deletePodsRateLimiter.set(o.DeletePodsFromKubernetesRateLimiter)
+		return nil
+	}, "DeletePodsFromKubernetesRateLimiter")
+	shared.reloader.register(func(_ context.Context, o *opts.Opts) error {
+		n.syncPodStatusRateLimiter.set(o.SyncPodStatusFromProviderRateLimiter)
+		return nil
+	}, "SyncPodStatusFromProviderRateLimiter")
+
+	n.cancelHTTP, n.certs, err = setupHTTPServer(ctx, n.provider, apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *virtualNode) withLogger(ctx context.Context) context.Context {
+	return log.WithLogger(ctx, log.G(ctx).WithFields(n.logFields))
+}
+
+// close stops the kubelet API server of the node.
+func (n *virtualNode) close() {
+	n.cancelHTTP()
+	if n.auth != nil {
+		n.auth.close()
+	}
+}
+
+// node returns the node registered with Kubernetes.
+func (n *virtualNode) node() *corev1.Node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.pNode
+}
+
+// health returns the state of the controllers of the node, and whether it
+// is run by this instance.
+func (n *virtualNode) health() (nodeHealth, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.runState == nil {
+		return nodeHealth{}, false
+	}
+	return *n.runState, true
+}
+
+// reloadTaint replaces the virtual-kubelet taint of the node.
+// The new taint is subject to the node policy, like it is when the node is
+// registered.
+func (n *virtualNode) reloadTaint(ctx context.Context, o *opts.Opts) error {
+	var newTaint *corev1.Taint
+	if !o.DisableTaint {
+		var err error
+		newTaint, err = getTaint(o)
+		if err != nil {
+			return err
+		}
+	}
+	reg, err := nodeRegistrationFromOpts(o)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	pNode := n.pNode.DeepCopy()
+	pNode.Spec.Taints = replaceTaint(pNode.Spec.Taints, n.taint, newTaint)
+	if err := applyNodePolicy(ctx, pNode, reg); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	if newTaint != nil && !reg.TaintPolicy.Allowed(newTaint.Key) {
+		newTaint = nil
+	}
+	n.pNode = pNode
+	n.taint = newTaint
+	running := n.running
+	n.mu.Unlock()
+
+	if !running {
+		return nil
+	}
+	_, err = applyNode(ctx, n.shared.client.CoreV1().Nodes(), pNode)
+	return err
+}
+
+// run runs the node and pod controllers until ctx is cancelled.
+// The `--on-shutdown` mode is only applied once stopping is closed, not
+// when ctx is cancelled because leadership is lost.
+func (n *virtualNode) run(ctx context.Context, stopping <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(n.withLogger(ctx))
+	defer cancel()
+	c := n.c
+	client := n.shared.client
+
+	nodeOpts := []node.NodeControllerOpt{
+		node.WithNodeStatusUpdateErrorHandler(func(ctx context.Context, err error) error {
+			if !k8serrors.IsNotFound(err) {
+				return err
+			}
+
+			log.G(ctx).Debug("node not found")
+			_, err = applyNode(ctx, client.CoreV1().Nodes(), n.node())
+			return err
+		}),
+	}
+	var leaseClient v1.LeaseInterface
+	if c.EnableNodeLease {
+		leaseClient = client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
+		nodeOpts = append(nodeOpts, node.WithNodeEnableLeaseV1(leaseClient, node.DefaultLeaseDuration))
+	}
+
+	pNode := n.node()
+	nodeProvider := newResourceNodeProvider(n.nodeProvider, n.extendedResources, n.reserved)
+	checks := n.shared.conditionChecks
+	if n.certs != nil {
+		checks = withBuiltinCheck(checks, servingCertCheck(n.certs))
+	}
+	if len(checks) > 0 {
+		cp := newConditionNodeProvider(ctx, nodeProvider, pNode, checks)
+		go cp.run(ctx)
+		nodeProvider = cp
+		pNode = cp.Node()
+	}
+	nodeRunner, err := node.NewNodeController(
+		nodeProvider,
+		pNode,
+		client.CoreV1().Nodes(),
+		nodeOpts...,
+	)
+	if err != nil {
+		log.G(ctx).Fatal(err)
+	}
+
+	recorder := n.shared.eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: path.Join(pNode.Name, "pod-controller")})
+	p, podClient := newAdmittingProvider(n.provider, client.CoreV1(), recorder, pNode, n.extendedResources)
+	pc, err := node.NewPodController(node.PodControllerConfig{
+		PodClient:                            podClient,
+		PodInformer:                          n.podInformer,
+		EventRecorder:                        recorder,
+		Provider:                             p,
+		SecretInformer:                       n.shared.secrets,
+		ConfigMapInformer:                    n.shared.configMaps,
+		ServiceInformer:                      n.shared.services,
+		SyncPodsFromKubernetesRateLimiter:    n.syncPodsRateLimiter,
+		DeletePodsFromKubernetesRateLimiter:  n.deletePodsRateLimiter,
+		SyncPodStatusFromProviderRateLimiter: n.syncPodStatusRateLimiter,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error setting up pod controller")
+	}
+
+	n.mu.Lock()
+	n.runState = &nodeHealth{ctx: ctx, pods: pc}
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.runState = nil
+		n.mu.Unlock()
+	}()
+
+	go func() {
+		if err := pc.Run(ctx, c.PodSyncWorkers); err != nil && errors.Cause(err) != context.Canceled {
+			log.G(ctx).Fatal(err)
+		}
+	}()
+
+	if c.StartupTimeout > 0 {
+		// If there is a startup timeout, it does two things:
+		// 1. It causes the VK to shutdown if we haven't gotten into an operational state in a time period
+		// 2. It prevents node advertisement from happening until we're in an operational state
+		err = waitFor(ctx, c.StartupTimeout, pc.Ready())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo a cordon done when shutting down last time.
+	if err := uncordonNode(ctx, client.CoreV1().Nodes(), c.NodeName); err != nil && !k8serrors.IsNotFound(err) {
+		log.G(ctx).WithError(err).Warn("Error uncordoning node")
+	}
+
+	// Register the node with the labels, annotations and taints of the
+	// provider, keeping the ones set by others.
+	n.mu.Lock()
+	n.running = true
+	pNode = n.pNode
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.running = false
+		n.mu.Unlock()
+	}()
+	if _, err := applyNode(ctx, client.CoreV1().Nodes(), pNode); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := nodeRunner.Run(ctx); err != nil {
+			log.G(ctx).Fatal(err)
+		}
+	}()
+	n.mu.Lock()
+	n.runState.nodes = nodeRunner
+	n.mu.Unlock()
+
+	var leaseLost chan error
+	if c.EnableNodeLease {
+		leaseLost = make(chan error, 1)
+		go func() {
+			leaseLost <- waitLeaseLost(ctx, client, c.NodeName, n.shared.self)
+		}()
+	}
+
+	log.G(ctx).Info("Initialized")
+
+	select {
+	case <-ctx.Done():
+	case err := <-leaseLost:
+		if err != nil {
+			// The lease and the node are up to the instance running the
+			// node now, the shutdown mode is not applied.
+			return err
+		}
+	}
+	mode := c.OnShutdown
+	select {
+	case <-stopping:
+	default:
+		mode = opts.ShutdownLeave
+	}
+	return shutdown(ctx, client, nodeRunner, n.shared.self, c, mode)
+}
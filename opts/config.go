@@ -0,0 +1,221 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the config file representation of Opts.
+// Config files may be written in either YAML or JSON.
+//
+// Every field is optional, fields which are not set in the file leave the
+// corresponding option untouched.
+// The `flag` tag holds the name of the command line flag which sets the same
+// option, see `Apply` for how this is used.
+// Fields tagged `sensitive` hold credentials, or the location of
+// credentials, and are redacted when the configuration is printed.
+//
+// Field names must match the name of the field in `Opts` they are applied to.
+type Config struct {
+	KubeConfigPath    *string `json:"kubeConfigPath,omitempty" flag:"kubeconfig" sensitive:"true"`
+	KubeNamespace     *string `json:"kubeNamespace,omitempty" flag:"namespace"`
+	KubeClusterDomain *string `json:"kubeClusterDomain,omitempty" flag:"cluster-domain"`
+
+	Namespaces        []string `json:"namespaces,omitempty" flag:"namespaces"`
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty" flag:"exclude-namespaces"`
+
+	ListenPort      *int32   `json:"listenPort,omitempty" flag:"port"`
+	ListenAddresses []string `json:"listenAddresses,omitempty" flag:"address"`
+
+	NodeName *string `json:"nodeName,omitempty" flag:"nodename"`
+	// Nodes can only be set in the config file, or with `cli.WithNodes`.
+	Nodes           []NodeSpec `json:"nodes,omitempty"`
+	OperatingSystem *string    `json:"operatingSystem,omitempty" flag:"os"`
+	NodeArch        *string    `json:"nodeArch,omitempty" flag:"node-arch"`
+
+	Provider           *string `json:"provider,omitempty" flag:"provider"`
+	ProviderConfigPath *string `json:"providerConfigPath,omitempty" flag:"provider-config" sensitive:"true"`
+
+	TaintKey     *string `json:"taintKey,omitempty" flag:"taint"`
+	TaintEffect  *string `json:"taintEffect,omitempty" flag:"taint-effect"`
+	TaintValue   *string `json:"taintValue,omitempty" flag:"taint-value"`
+	DisableTaint *bool   `json:"disableTaint,omitempty" flag:"disable-taint"`
+
+	RegisterWithTaints []string `json:"registerWithTaints,omitempty" flag:"register-with-taints"`
+	NodeLabels         []string `json:"nodeLabels,omitempty" flag:"node-labels"`
+	NodeAnnotations    []string `json:"nodeAnnotations,omitempty" flag:"node-annotations"`
+
+	AllowedNodeLabels []string `json:"allowedNodeLabels,omitempty" flag:"allowed-node-labels"`
+	DeniedNodeLabels  []string `json:"deniedNodeLabels,omitempty" flag:"denied-node-labels"`
+	AllowedNodeTaints []string `json:"allowedNodeTaints,omitempty" flag:"allowed-node-taints"`
+	DeniedNodeTaints  []string `json:"deniedNodeTaints,omitempty" flag:"denied-node-taints"`
+	NodeRestriction   *bool    `json:"nodeRestriction,omitempty" flag:"node-restriction"`
+	StrictNodePolicy  *bool    `json:"strictNodePolicy,omitempty" flag:"strict-node-policy"`
+
+	SystemReserved    []string `json:"systemReserved,omitempty" flag:"system-reserved"`
+	ExtendedResources []string `json:"extendedResources,omitempty" flag:"extended-resource"`
+
+	MetricsAddr *string `json:"metricsAddr,omitempty" flag:"metrics-addr"`
+	HealthAddr  *string `json:"healthAddr,omitempty" flag:"health-addr"`
+
+	TLSCertFile        *string  `json:"tlsCertFile,omitempty" flag:"tls-cert-file" sensitive:"true"`
+	TLSPrivateKeyFile  *string  `json:"tlsPrivateKeyFile,omitempty" flag:"tls-private-key-file" sensitive:"true"`
+	ServerTLSBootstrap *bool    `json:"serverTLSBootstrap,omitempty" flag:"rotate-server-certificates"`
+	TLSMode            *string  `json:"tlsMode,omitempty" flag:"tls-mode"`
+	TLSMinVersion      *string  `json:"tlsMinVersion,omitempty" flag:"tls-min-version"`
+	TLSCipherSuites    []string `json:"tlsCipherSuites,omitempty" flag:"tls-cipher-suites"`
+
+	MasterURI *string `json:"masterURI,omitempty" flag:"master-uri" sensitive:"true"`
+
+	ClientCACert                *string `json:"clientCACert,omitempty" flag:"client-verify-ca" sensitive:"true"`
+	AllowUnauthenticatedClients *bool   `json:"allowUnauthenticatedClients,omitempty" flag:"no-verify-clients"`
+
+	PodSyncWorkers       *int             `json:"podSyncWorkers,omitempty" flag:"pod-sync-workers"`
+	InformerResyncPeriod *metav1.Duration `json:"informerResyncPeriod,omitempty" flag:"full-resync-period"`
+
+	EnableNodeLease *bool `json:"enableNodeLease,omitempty" flag:"enable-node-lease"`
+	ForceTakeover   *bool `json:"forceTakeover,omitempty" flag:"force-takeover"`
+
+	LeaderElect                  *bool            `json:"leaderElect,omitempty" flag:"leader-elect"`
+	LeaderElectLeaseDuration     *metav1.Duration `json:"leaderElectLeaseDuration,omitempty" flag:"leader-elect-lease-duration"`
+	LeaderElectRenewDeadline     *metav1.Duration `json:"leaderElectRenewDeadline,omitempty" flag:"leader-elect-renew-deadline"`
+	LeaderElectRetryPeriod       *metav1.Duration `json:"leaderElectRetryPeriod,omitempty" flag:"leader-elect-retry-period"`
+	LeaderElectResourceNamespace *string          `json:"leaderElectResourceNamespace,omitempty" flag:"leader-elect-resource-namespace"`
+	LeaderElectResourceName      *string          `json:"leaderElectResourceName,omitempty" flag:"leader-elect-resource-name"`
+
+	StartupTimeout        *metav1.Duration `json:"startupTimeout,omitempty" flag:"startup-timeout"`
+	OnShutdown            *string          `json:"onShutdown,omitempty" flag:"on-shutdown"`
+	ShutdownTimeout       *metav1.Duration `json:"shutdownTimeout,omitempty" flag:"shutdown-timeout"`
+	StreamIdleTimeout     *metav1.Duration `json:"streamIdleTimeout,omitempty"`
+	StreamCreationTimeout *metav1.Duration `json:"streamCreationTimeout,omitempty"`
+
+	KubeAPIQPS   *int32 `json:"kubeAPIQPS,omitempty" flag:"kube-api-qps"`
+	KubeAPIBurst *int32 `json:"kubeAPIBurst,omitempty" flag:"kube-api-burst"`
+
+	// Rate limiters are set using the syntax described in `ParseRateLimiter`.
+	SyncPodsFromKubernetesRateLimiter    *string `json:"syncPodsFromKubernetesRateLimiter,omitempty" flag:"sync-pods-rate-limiter"`
+	DeletePodsFromKubernetesRateLimiter  *string `json:"deletePodsFromKubernetesRateLimiter,omitempty" flag:"delete-pods-rate-limiter"`
+	SyncPodStatusFromProviderRateLimiter *string `json:"syncPodStatusFromProviderRateLimiter,omitempty" flag:"sync-pod-status-rate-limiter"`
+
+	Version *string `json:"version,omitempty"`
+
+	LogLevel *string `json:"logLevel,omitempty" flag:"log-level"`
+
+	Authentication *AuthenticationConfig `json:"authentication,omitempty"`
+	Authorization  *AuthorizationConfig  `json:"authorization,omitempty"`
+}
+
+// AuthenticationConfig is the config file representation of Authentication.
+type AuthenticationConfig struct {
+	Webhook *WebhookAuthenticationConfig `json:"webhook,omitempty"`
+}
+
+// WebhookAuthenticationConfig is the config file representation of WebhookAuthentication.
+type WebhookAuthenticationConfig struct {
+	Enabled  *bool            `json:"enabled,omitempty" flag:"authentication-token-webhook"`
+	CacheTTL *metav1.Duration `json:"cacheTTL,omitempty" flag:"authentication-token-webhook-cache-ttl"`
+}
+
+// AuthorizationConfig is the config file representation of Authorization.
+type AuthorizationConfig struct {
+	Webhook *WebhookAuthorizationConfig `json:"webhook,omitempty"`
+}
+
+// WebhookAuthorizationConfig is the config file representation of WebhookAuthorization.
+type WebhookAuthorizationConfig struct {
+	CacheAuthorizedTTL   *metav1.Duration `json:"cacheAuthorizedTTL,omitempty" flag:"authorization-webhook-cache-authorized-ttl"`
+	CacheUnauthorizedTTL *metav1.Duration `json:"cacheUnauthorizedTTL,omitempty" flag:"authorization-webhook-cache-unauthorized-ttl"`
+}
+
+// LoadConfig reads the config file at the given path.
+// Unknown fields are treated as an error so typos do not go unnoticed.
+func LoadConfig(p string) (*Config, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading config file")
+	}
+
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, errdefs.AsInvalidInput(errors.Wrapf(err, "error parsing config file %s", p))
+	}
+	return &c, nil
+}
+
+// Apply sets all the values from the config file on the passed in options.
+//
+// skip is called with the flag names of every option that has any, if it
+// returns true the option is left untouched. This is used to give flags
+// (and environment variables) precedence over the config file.
+// skip may be nil.
+//
+// An error is returned if a value in the file cannot be converted to the
+// option, in which case some options may already have been set.
+func (c *Config) Apply(o *Opts, skip func(flag string) bool) error {
+	return applyConfig(reflect.ValueOf(c).Elem(), reflect.ValueOf(o).Elem(), skip)
+}
+
+var (
+	metav1DurationType = reflect.TypeOf(metav1.Duration{})
+	durationType       = reflect.TypeOf(time.Duration(0))
+	rateLimiterType    = reflect.TypeOf((*workqueue.RateLimiter)(nil)).Elem()
+)
+
+func applyConfig(src, dst reflect.Value, skip func(string) bool) error {
+	t := src.Type()
+	for i := 0; i < t.NumField(); i++ {
+		v := src.Field(i)
+		if v.IsNil() {
+			continue
+		}
+
+		f := t.Field(i)
+		if flag := f.Tag.Get("flag"); flag != "" && skip != nil && skip(flag) {
+			continue
+		}
+
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+		d := dst.FieldByName(f.Name)
+		switch {
+		case v.Type() == metav1DurationType && d.Type() == durationType:
+			d.Set(reflect.ValueOf(v.Interface().(metav1.Duration).Duration))
+		case v.Kind() == reflect.String && d.Type() == rateLimiterType:
+			l, err := ParseRateLimiter(v.String())
+			if err != nil {
+				return errors.Wrapf(err, "invalid value for %s in config file", strings.Split(f.Tag.Get("json"), ",")[0])
+			}
+			d.Set(reflect.ValueOf(l))
+		case v.Kind() == reflect.Struct && v.Type() != metav1DurationType:
+			if err := applyConfig(v, d, skip); err != nil {
+				return err
+			}
+		default:
+			d.Set(v)
+		}
+	}
+	return nil
+}
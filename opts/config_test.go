@@ -0,0 +1,134 @@
+package opts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vk-config")
+	assert.NilError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	p := filepath.Join(dir, name)
+	assert.NilError(t, ioutil.WriteFile(p, []byte(data), 0600))
+	return p
+}
+
+func TestLoadConfig(t *testing.T) {
+	yamlConfig := `
+nodeName: from-yaml
+podSyncWorkers: 3
+informerResyncPeriod: 30s
+enableNodeLease: false
+authentication:
+  webhook:
+    enabled: true
+    cacheTTL: 2m
+authorization:
+  webhook:
+    cacheAuthorizedTTL: 5m
+syncPodsFromKubernetesRateLimiter: exponential:1s-10s
+nodes:
+- name: node-a
+  nodeLabels: [region=a]
+`
+	jsonConfig := `{
+	"nodeName": "from-yaml",
+	"podSyncWorkers": 3,
+	"informerResyncPeriod": "30s",
+	"enableNodeLease": false,
+	"authentication": {"webhook": {"enabled": true, "cacheTTL": "2m"}},
+	"authorization": {"webhook": {"cacheAuthorizedTTL": "5m"}},
+	"syncPodsFromKubernetesRateLimiter": "exponential:1s-10s",
+	"nodes": [{"name": "node-a", "nodeLabels": ["region=a"]}]
+}`
+
+	for name, data := range map[string]string{"config.yaml": yamlConfig, "config.json": jsonConfig} {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, name, data))
+			assert.NilError(t, err)
+
+			o := New()
+			assert.NilError(t, cfg.Apply(o, nil))
+
+			assert.Check(t, is.Equal(o.NodeName, "from-yaml"))
+			assert.Check(t, is.DeepEqual(o.Nodes, []NodeSpec{{Name: "node-a", NodeLabels: []string{"region=a"}}}))
+			assert.Check(t, is.Equal(o.PodSyncWorkers, 3))
+			assert.Check(t, is.Equal(o.InformerResyncPeriod, 30*time.Second))
+			assert.Check(t, !o.EnableNodeLease)
+			assert.Check(t, o.Authentication.Webhook.Enabled)
+			assert.Check(t, is.Equal(o.Authentication.Webhook.CacheTTL.Duration, 2*time.Minute))
+			assert.Check(t, is.Equal(o.Authorization.Webhook.CacheAuthorizedTTL.Duration, 5*time.Minute))
+			assert.Check(t, is.Equal(RateLimiterSpec(o.SyncPodsFromKubernetesRateLimiter), "exponential:1s-10s"))
+
+			// Values not in the file keep their defaults
+			assert.Check(t, is.Equal(o.KubeClusterDomain, DefaultKubeClusterDomain))
+			assert.Check(t, is.Equal(o.ListenPort, int32(DefaultListenPort)))
+			assert.Check(t, is.Equal(RateLimiterSpec(o.DeletePodsFromKubernetesRateLimiter), DefaultRateLimiterSpec))
+		})
+	}
+
+	t.Run("invalid rate limiter", func(t *testing.T) {
+		cfg, err := LoadConfig(writeConfig(t, "config.yaml", "deletePodsFromKubernetesRateLimiter: bucket:fast\n"))
+		assert.NilError(t, err)
+		err = cfg.Apply(New(), nil)
+		assert.ErrorContains(t, err, "deletePodsFromKubernetesRateLimiter")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, "config.yaml", "nodeNmae: typo\n"))
+		assert.ErrorContains(t, err, "nodeNmae")
+	})
+}
+
+func TestConfigApplySkip(t *testing.T) {
+	p := writeConfig(t, "config.yaml", `
+nodeName: from-file
+taintValue: from-file
+authentication:
+  webhook:
+    cacheTTL: 1m
+`)
+	cfg, err := LoadConfig(p)
+	assert.NilError(t, err)
+
+	o := New()
+	o.NodeName = "from-flag"
+	err = cfg.Apply(o, func(flag string) bool {
+		return flag == "nodename" || flag == "authentication-token-webhook-cache-ttl"
+	})
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Equal(o.NodeName, "from-flag"))
+	assert.Check(t, is.Equal(o.TaintValue, "from-file"))
+	assert.Check(t, is.Equal(o.Authentication.Webhook.CacheTTL.Duration, time.Duration(0)))
+}
+
+// TestConfigCoversOpts makes sure new fields in Opts are also added to Config.
+func TestConfigCoversOpts(t *testing.T) {
+	notInConfig := map[string]bool{
+		"ConfigPath":        true,
+		"KubeletConfigPath": true,
+	}
+
+	ot := reflect.TypeOf(Opts{})
+	ct := reflect.TypeOf(Config{})
+	for i := 0; i < ot.NumField(); i++ {
+		name := ot.Field(i).Name
+		if notInConfig[name] {
+			continue
+		}
+		_, ok := ct.FieldByName(name)
+		assert.Check(t, ok, "Opts.%s is missing from Config", name)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	kubeletconfigv1beta1 "k8s.io/kubelet/config/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+// kubeletConfiguration is the KubeletConfiguration type this version of
+// virtual-kubelet is built against, plus the newer fields we support.
+type kubeletConfiguration struct {
+	kubeletconfigv1beta1.KubeletConfiguration
+
+	// RegisterWithTaints was added to KubeletConfiguration in Kubernetes 1.23.
+	RegisterWithTaints []corev1.Taint `json:"registerWithTaints,omitempty"`
+}
+
+// kubeletConfigFields maps the KubeletConfiguration fields which have an
+// equivalent option to the Config field they set.
+// Fields are named by their path in the file.
+//
+// `authentication.anonymous` is not mapped: the kubelet still
+// authorizes anonymous requests as `system:anonymous`, while
+// `AllowUnauthenticatedClients` turns client authentication off entirely.
+var kubeletConfigFields = map[string]func(kc *kubeletConfiguration, c *Config){
+	"address": func(kc *kubeletConfiguration, c *Config) {
+		c.ListenAddresses = []string{kc.Address}
+	},
+	"port": func(kc *kubeletConfiguration, c *Config) {
+		c.ListenPort = &kc.Port
+	},
+	"tlsCertFile": func(kc *kubeletConfiguration, c *Config) {
+		c.TLSCertFile = &kc.TLSCertFile
+	},
+	"tlsPrivateKeyFile": func(kc *kubeletConfiguration, c *Config) {
+		c.TLSPrivateKeyFile = &kc.TLSPrivateKeyFile
+	},
+	"serverTLSBootstrap": func(kc *kubeletConfiguration, c *Config) {
+		c.ServerTLSBootstrap = &kc.ServerTLSBootstrap
+	},
+	"tlsMinVersion": func(kc *kubeletConfiguration, c *Config) {
+		c.TLSMinVersion = &kc.TLSMinVersion
+	},
+	"tlsCipherSuites": func(kc *kubeletConfiguration, c *Config) {
+		c.TLSCipherSuites = kc.TLSCipherSuites
+	},
+	"clusterDomain": func(kc *kubeletConfiguration, c *Config) {
+		c.KubeClusterDomain = &kc.ClusterDomain
+	},
+	"streamingConnectionIdleTimeout": func(kc *kubeletConfiguration, c *Config) {
+		c.StreamIdleTimeout = &kc.StreamingConnectionIdleTimeout
+	},
+	"registerWithTaints": func(kc *kubeletConfiguration, c *Config) {
+		c.RegisterWithTaints = make([]string, 0, len(kc.RegisterWithTaints))
+		for _, t := range kc.RegisterWithTaints {
+			c.RegisterWithTaints = append(c.RegisterWithTaints, formatTaint(t))
+		}
+	},
+	"systemReserved": func(kc *kubeletConfiguration, c *Config) {
+		c.SystemReserved = make([]string, 0, len(kc.SystemReserved))
+		for k, v := range kc.SystemReserved {
+			c.SystemReserved = append(c.SystemReserved, k+"="+v)
+		}
+		sort.Strings(c.SystemReserved)
+	},
+	"kubeAPIQPS": func(kc *kubeletConfiguration, c *Config) {
+		c.KubeAPIQPS = kc.KubeAPIQPS
+	},
+	"kubeAPIBurst": func(kc *kubeletConfiguration, c *Config) {
+		c.KubeAPIBurst = &kc.KubeAPIBurst
+	},
+	"authentication.x509.clientCAFile": func(kc *kubeletConfiguration, c *Config) {
+		c.ClientCACert = &kc.Authentication.X509.ClientCAFile
+	},
+	"authentication.webhook.enabled": func(kc *kubeletConfiguration, c *Config) {
+		authenticationWebhook(c).Enabled = kc.Authentication.Webhook.Enabled
+	},
+	"authentication.webhook.cacheTTL": func(kc *kubeletConfiguration, c *Config) {
+		authenticationWebhook(c).CacheTTL = &kc.Authentication.Webhook.CacheTTL
+	},
+	"authorization.webhook.cacheAuthorizedTTL": func(kc *kubeletConfiguration, c *Config) {
+		authorizationWebhook(c).CacheAuthorizedTTL = &kc.Authorization.Webhook.CacheAuthorizedTTL
+	},
+	"authorization.webhook.cacheUnauthorizedTTL": func(kc *kubeletConfiguration, c *Config) {
+		authorizationWebhook(c).CacheUnauthorizedTTL = &kc.Authorization.Webhook.CacheUnauthorizedTTL
+	},
+}
+
+// LoadKubeletConfig reads a `kubelet.config.k8s.io/v1beta1` KubeletConfiguration
+// file and converts the fields that have an equivalent option to a Config.
+//
+// The second return value lists the fields set in the file which are not
+// supported and were ignored.
+func LoadKubeletConfig(p string) (*Config, []string, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error reading kubelet config file")
+	}
+
+	var kc kubeletConfiguration
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, nil, errdefs.AsInvalidInput(errors.Wrapf(err, "error parsing kubelet config file %s", p))
+	}
+	gvk := kubeletconfigv1beta1.SchemeGroupVersion.WithKind("KubeletConfiguration")
+	if kc.APIVersion != gvk.GroupVersion().String() || kc.Kind != gvk.Kind {
+		return nil, nil, errdefs.InvalidInputf("kubelet config file %s must be a %s %s, got %s %s", p, gvk.GroupVersion(), gvk.Kind, kc.APIVersion, kc.Kind)
+	}
+
+	// The typed config can't tell unset fields from zero values, so the raw
+	// fields are used to find out what is set in the file.
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, nil, errdefs.AsInvalidInput(errors.Wrapf(err, "error parsing kubelet config file %s", p))
+	}
+	delete(raw, "apiVersion")
+	delete(raw, "kind")
+
+	var (
+		c           Config
+		unsupported []string
+	)
+	walkFields("", raw, func(path string) bool {
+		if set, ok := kubeletConfigFields[path]; ok {
+			set(&kc, &c)
+			return true
+		}
+		for supported := range kubeletConfigFields {
+			if strings.HasPrefix(supported, path+".") {
+				return false
+			}
+		}
+		unsupported = append(unsupported, path)
+		return true
+	})
+	sort.Strings(unsupported)
+
+	return &c, unsupported, nil
+}
+
+// walkFields calls f with the path of every field in m.
+// If f returns false the field's value is walked too, if it is an object.
+func walkFields(prefix string, m map[string]interface{}, f func(path string) bool) {
+	for k, v := range m {
+		path := prefix + k
+		if f(path) {
+			continue
+		}
+		if child, ok := v.(map[string]interface{}); ok {
+			walkFields(path+".", child, f)
+		}
+	}
+}
+
+func authenticationWebhook(c *Config) *WebhookAuthenticationConfig {
+	if c.Authentication == nil {
+		c.Authentication = &AuthenticationConfig{}
+	}
+	if c.Authentication.Webhook == nil {
+		c.Authentication.Webhook = &WebhookAuthenticationConfig{}
+	}
+	return c.Authentication.Webhook
+}
+
+func authorizationWebhook(c *Config) *WebhookAuthorizationConfig {
+	if c.Authorization == nil {
+		c.Authorization = &AuthorizationConfig{}
+	}
+	if c.Authorization.Webhook == nil {
+		c.Authorization.Webhook = &WebhookAuthorizationConfig{}
+	}
+	return c.Authorization.Webhook
+}
+
+// formatTaint formats a taint in the form parsed by `ParseTaints`.
+func formatTaint(t corev1.Taint) string {
+	s := t.Key
+	if t.Value != "" {
+		s += "=" + t.Value
+	}
+	return s + ":" + string(t.Effect)
+}
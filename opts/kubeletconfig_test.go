@@ -0,0 +1,84 @@
+package opts
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestLoadKubeletConfig(t *testing.T) {
+	p := writeConfig(t, "kubelet.yaml", `
+apiVersion: kubelet.config.k8s.io/v1beta1
+kind: KubeletConfiguration
+address: 10.0.0.1
+port: 10251
+tlsCertFile: /etc/kubelet/cert.pem
+tlsPrivateKeyFile: /etc/kubelet/key.pem
+serverTLSBootstrap: true
+tlsMinVersion: VersionTLS13
+tlsCipherSuites:
+- TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+kubeAPIQPS: 20
+kubeAPIBurst: 40
+systemReserved:
+  memory: 1Gi
+  cpu: 500m
+registerWithTaints:
+- key: a
+  value: b
+  effect: NoSchedule
+- key: c
+  effect: NoExecute
+authentication:
+  x509:
+    clientCAFile: /etc/kubelet/ca.pem
+  webhook:
+    enabled: true
+    cacheTTL: 1m
+  anonymous:
+    enabled: false
+authorization:
+  mode: Webhook
+  webhook:
+    cacheAuthorizedTTL: 2m
+    cacheUnauthorizedTTL: 3s
+maxPods: 110
+evictionHard:
+  memory.available: 100Mi
+`)
+
+	cfg, unsupported, err := LoadKubeletConfig(p)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(unsupported, []string{"authentication.anonymous", "authorization.mode", "evictionHard", "maxPods"}))
+
+	o := New()
+	assert.NilError(t, cfg.Apply(o, nil))
+	assert.Check(t, is.DeepEqual(o.ListenAddresses, []string{"10.0.0.1"}))
+	assert.Check(t, is.Equal(o.ListenPort, int32(10251)))
+	assert.Check(t, is.Equal(o.TLSCertFile, "/etc/kubelet/cert.pem"))
+	assert.Check(t, is.Equal(o.TLSPrivateKeyFile, "/etc/kubelet/key.pem"))
+	assert.Check(t, o.ServerTLSBootstrap)
+	assert.Check(t, is.Equal(o.TLSMinVersion, "VersionTLS13"))
+	assert.Check(t, is.DeepEqual(o.TLSCipherSuites, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}))
+	assert.Check(t, is.Equal(o.ClientCACert, "/etc/kubelet/ca.pem"))
+	assert.Check(t, is.Equal(o.KubeAPIQPS, int32(20)))
+	assert.Check(t, is.Equal(o.KubeAPIBurst, int32(40)))
+	assert.Check(t, is.DeepEqual(o.RegisterWithTaints, []string{"a=b:NoSchedule", "c:NoExecute"}))
+	assert.Check(t, is.DeepEqual(o.SystemReserved, []string{"cpu=500m", "memory=1Gi"}))
+	assert.Check(t, o.Authentication.Webhook.Enabled)
+	assert.Check(t, !o.AllowUnauthenticatedClients)
+	assert.Check(t, is.Equal(o.Authentication.Webhook.CacheTTL.Duration, time.Minute))
+	assert.Check(t, is.Equal(o.Authorization.Webhook.CacheAuthorizedTTL.Duration, 2*time.Minute))
+	assert.Check(t, is.Equal(o.Authorization.Webhook.CacheUnauthorizedTTL.Duration, 3*time.Second))
+
+	// Fields not in the file are left alone
+	assert.Check(t, is.Equal(o.KubeClusterDomain, DefaultKubeClusterDomain))
+	assert.Check(t, is.Equal(o.StreamIdleTimeout, DefaultStreamIdleTimeout))
+
+	t.Run("wrong kind", func(t *testing.T) {
+		_, _, err := LoadKubeletConfig(writeConfig(t, "kubelet.yaml", "apiVersion: v1\nkind: ConfigMap\n"))
+		assert.ErrorContains(t, err, "must be a kubelet.config.k8s.io/v1beta1 KubeletConfiguration")
+	})
+}
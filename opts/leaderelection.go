@@ -0,0 +1,62 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+// LeaderElectionLease returns the namespace and name of the leader election
+// lease. The name defaults to `virtual-kubelet-<node name>`, so it does not
+// clash with the node heartbeat lease.
+func (o *Opts) LeaderElectionLease() (namespace, name string) {
+	name = o.LeaderElectResourceName
+	if name == "" {
+		name = "virtual-kubelet-" + o.NodeName
+	}
+	return o.LeaderElectResourceNamespace, name
+}
+
+func (o *Opts) validateLeaderElection() []error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, errdefs.InvalidInputf(format, args...))
+	}
+
+	namespace, name := o.LeaderElectionLease()
+	if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+		invalid("invalid leader election namespace %q: %s", namespace, strings.Join(msgs, ", "))
+	}
+	if msgs := validation.IsDNS1123Subdomain(name); len(msgs) > 0 {
+		invalid("invalid leader election lease name %q: %s", name, strings.Join(msgs, ", "))
+	}
+
+	// These are the constraints `leaderelection.NewLeaderElector` checks.
+	if o.LeaderElectRetryPeriod <= 0 {
+		invalid("leader election retry period must be greater than 0")
+	}
+	if o.LeaderElectLeaseDuration <= o.LeaderElectRenewDeadline {
+		invalid("leader election lease duration (%s) must be greater than the renew deadline (%s)", o.LeaderElectLeaseDuration, o.LeaderElectRenewDeadline)
+	}
+	if minDeadline := time.Duration(leaderelection.JitterFactor * float64(o.LeaderElectRetryPeriod)); o.LeaderElectRenewDeadline <= minDeadline {
+		invalid("leader election renew deadline (%s) must be greater than %s, %v times the retry period", o.LeaderElectRenewDeadline, minDeadline, leaderelection.JitterFactor)
+	}
+	return errs
+}
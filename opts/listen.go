@@ -0,0 +1,57 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ListenAddrs returns the addresses the kubelet API server listens on, in
+// `host:port` form.
+//
+// Every entry in `ListenAddresses` is either an IP address, which is combined
+// with `ListenPort`, or an `ip:port` pair. IPv6 addresses may be enclosed in
+// brackets, and must be when a port is given, e.g. `[::1]:10250`.
+// To listen on both IPv4 and IPv6 list an address of each family, e.g.
+// `0.0.0.0,::`.
+//
+// If no addresses are set, all interfaces are used.
+func (o *Opts) ListenAddrs() ([]string, error) {
+	if len(o.ListenAddresses) == 0 {
+		return []string{net.JoinHostPort("", strconv.Itoa(int(o.ListenPort)))}, nil
+	}
+
+	addrs := make([]string, 0, len(o.ListenAddresses))
+	for _, a := range o.ListenAddresses {
+		host, port := strings.Trim(a, "[]"), strconv.Itoa(int(o.ListenPort))
+		if h, p, err := net.SplitHostPort(a); err == nil {
+			host, port = h, p
+		}
+
+		if net.ParseIP(host) == nil {
+			return nil, errdefs.InvalidInputf("invalid listen address %q: %q is not an IP address", a, host)
+		}
+		if p, err := strconv.Atoi(port); err != nil || len(validation.IsValidPortNum(p)) > 0 {
+			return nil, errdefs.InvalidInputf("invalid listen address %q: invalid port %q", a, port)
+		}
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs, nil
+}
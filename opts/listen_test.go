@@ -0,0 +1,26 @@
+package opts
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestListenAddrs(t *testing.T) {
+	o := New()
+	addrs, err := o.ListenAddrs()
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(addrs, []string{":10250"}))
+
+	o.ListenAddresses = []string{"0.0.0.0", "::", "[fe80::1]", "127.0.0.1:1234", "[::1]:4321"}
+	addrs, err = o.ListenAddrs()
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(addrs, []string{"0.0.0.0:10250", "[::]:10250", "[fe80::1]:10250", "127.0.0.1:1234", "[::1]:4321"}))
+
+	for _, a := range []string{"localhost", "example.com:10250", "127.0.0.1:0", "127.0.0.1:http", "::1:10250"} {
+		o.ListenAddresses = []string{a}
+		_, err := o.ListenAddrs()
+		assert.Check(t, err != nil, a)
+	}
+}
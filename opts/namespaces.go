@@ -0,0 +1,109 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NamespaceSet is a set of namespaces to watch.
+type NamespaceSet struct {
+	// Include lists the namespaces in the set. When empty the set holds all
+	// namespaces except the ones in Exclude.
+	Include []string
+	// Exclude lists the namespaces which are not in the set. It is only used
+	// when Include is empty.
+	Exclude []string
+	// None is set when every namespace to include is excluded, the set is
+	// then empty rather than holding all namespaces.
+	None bool
+}
+
+// All reports whether the set holds every namespace.
+func (s NamespaceSet) All() bool {
+	return !s.None && len(s.Include) == 0 && len(s.Exclude) == 0
+}
+
+// Has reports whether namespace is in the set.
+func (s NamespaceSet) Has(namespace string) bool {
+	if s.None {
+		return false
+	}
+	if len(s.Include) > 0 {
+		return contains(s.Include, namespace)
+	}
+	return !contains(s.Exclude, namespace)
+}
+
+func (s NamespaceSet) String() string {
+	switch {
+	case s.None:
+		return "none"
+	case len(s.Include) > 0:
+		return strings.Join(s.Include, ",")
+	case len(s.Exclude) > 0:
+		return "all except " + strings.Join(s.Exclude, ",")
+	default:
+		return "all"
+	}
+}
+
+// WatchedNamespaces returns the namespaces to watch for pods and other
+// resources.
+//
+// These are the namespaces in `Namespaces`, or `KubeNamespace` if that is not
+// set, without the ones in `ExcludeNamespaces`. If neither is set, all
+// namespaces but the excluded ones are watched. If every namespace is
+// excluded, none is watched.
+func (o *Opts) WatchedNamespaces() NamespaceSet {
+	include := o.Namespaces
+	if len(include) == 0 && o.KubeNamespace != corev1.NamespaceAll {
+		include = []string{o.KubeNamespace}
+	}
+	exclude := dedup(o.ExcludeNamespaces)
+
+	if len(include) == 0 {
+		return NamespaceSet{Exclude: exclude}
+	}
+	var s NamespaceSet
+	for _, ns := range dedup(include) {
+		if !contains(exclude, ns) {
+			s.Include = append(s.Include, ns)
+		}
+	}
+	s.None = len(s.Include) == 0
+	return s
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func dedup(l []string) []string {
+	var out []string
+	for _, v := range l {
+		if !contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
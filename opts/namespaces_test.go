@@ -0,0 +1,60 @@
+package opts
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestWatchedNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		namespace string
+		include   []string
+		exclude   []string
+		expected  NamespaceSet
+		has       map[string]bool
+	}{
+		{
+			name: "all",
+			has:  map[string]bool{"a": true, "kube-system": true},
+		},
+		{
+			name:      "namespace",
+			namespace: "a",
+			expected:  NamespaceSet{Include: []string{"a"}},
+			has:       map[string]bool{"a": true, "b": false},
+		},
+		{
+			name:     "namespaces",
+			include:  []string{"a", "b", "a", "c"},
+			exclude:  []string{"c"},
+			expected: NamespaceSet{Include: []string{"a", "b"}},
+			has:      map[string]bool{"a": true, "b": true, "c": false, "d": false},
+		},
+		{
+			name:     "exclude",
+			exclude:  []string{"kube-system", "kube-system"},
+			expected: NamespaceSet{Exclude: []string{"kube-system"}},
+			has:      map[string]bool{"a": true, "kube-system": false},
+		},
+		{
+			name:      "namespace excluded",
+			namespace: "a",
+			exclude:   []string{"a"},
+			expected:  NamespaceSet{None: true},
+			has:       map[string]bool{"a": false, "b": false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Opts{KubeNamespace: tc.namespace, Namespaces: tc.include, ExcludeNamespaces: tc.exclude}
+			s := o.WatchedNamespaces()
+			assert.Check(t, is.DeepEqual(s, tc.expected))
+			assert.Check(t, is.Equal(s.All(), !tc.expected.None && len(tc.expected.Include)+len(tc.expected.Exclude) == 0))
+			for ns, has := range tc.has {
+				assert.Check(t, is.Equal(s.Has(ns), has), ns)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"strings"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ParseTaints parses taints in the form `key[=value]:effect`, as used by the
+// kubelet's `--register-with-taints` flag.
+// If the same key and effect is given more than once, the last one wins.
+func ParseTaints(specs []string) ([]corev1.Taint, error) {
+	var taints []corev1.Taint
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ":")
+		if i < 0 {
+			return nil, errdefs.InvalidInputf("invalid taint %q: expected key[=value]:effect", spec)
+		}
+
+		t := corev1.Taint{Effect: corev1.TaintEffect(spec[i+1:])}
+		t.Key = spec[:i]
+		if j := strings.Index(t.Key, "="); j >= 0 {
+			t.Key, t.Value = t.Key[:j], t.Key[j+1:]
+		}
+
+		if msgs := validation.IsQualifiedName(t.Key); len(msgs) > 0 {
+			return nil, errdefs.InvalidInputf("invalid taint %q: %s", spec, strings.Join(msgs, ", "))
+		}
+		if t.Value != "" {
+			if msgs := validation.IsValidLabelValue(t.Value); len(msgs) > 0 {
+				return nil, errdefs.InvalidInputf("invalid taint %q: %s", spec, strings.Join(msgs, ", "))
+			}
+		}
+		switch t.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectNoExecute, corev1.TaintEffectPreferNoSchedule:
+		default:
+			return nil, errdefs.InvalidInputf("invalid taint %q: taint effect %q is not supported", spec, t.Effect)
+		}
+
+		taints = mergeTaint(taints, t)
+	}
+	return taints, nil
+}
+
+// mergeTaint adds t to taints, replacing any taint with the same key and effect.
+func mergeTaint(taints []corev1.Taint, t corev1.Taint) []corev1.Taint {
+	for i := range taints {
+		if taints[i].MatchTaint(&t) {
+			taints[i] = t
+			return taints
+		}
+	}
+	return append(taints, t)
+}
+
+// ParseNodeLabels parses node labels in the form `key=value`.
+// If the same key is given more than once, the last one wins.
+func ParseNodeLabels(kvs []string) (map[string]string, error) {
+	return parseKeyValues("label", kvs, func(k, v string) []string {
+		return append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...)
+	})
+}
+
+// ParseNodeAnnotations parses node annotations in the form `key=value`.
+// If the same key is given more than once, the last one wins.
+func ParseNodeAnnotations(kvs []string) (map[string]string, error) {
+	return parseKeyValues("annotation", kvs, func(k, _ string) []string {
+		return validation.IsQualifiedName(strings.ToLower(k))
+	})
+}
+
+// ParseResourceList parses resource quantities in the form `name=quantity`,
+// e.g. `cpu=500m` or `memory=1Gi`.
+// If the same resource is given more than once, the last one wins.
+func ParseResourceList(kvs []string) (corev1.ResourceList, error) {
+	m, err := parseKeyValues("resource", kvs, func(k, v string) []string {
+		msgs := validation.IsQualifiedName(k)
+		if q, err := resource.ParseQuantity(v); err != nil {
+			msgs = append(msgs, err.Error())
+		} else if q.Sign() < 0 {
+			msgs = append(msgs, "quantity must not be negative")
+		}
+		return msgs
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l := make(corev1.ResourceList, len(m))
+	for k, v := range m {
+		l[corev1.ResourceName(k)] = resource.MustParse(v)
+	}
+	return l, nil
+}
+
+// ParseExtendedResources parses extended resources in the form
+// `name=quantity`, e.g. `example.com/license=10`, like `ParseResourceList`.
+// The names must be extended resource names, i.e. outside the kubernetes.io
+// namespace, and the quantities whole numbers.
+func ParseExtendedResources(kvs []string) (corev1.ResourceList, error) {
+	l, err := ParseResourceList(kvs)
+	if err != nil {
+		return nil, err
+	}
+	for name, q := range l {
+		if !isExtendedResourceName(name) {
+			return nil, errdefs.InvalidInputf("invalid extended resource %q: the name must have a domain prefix outside kubernetes.io", name)
+		}
+		if q.MilliValue()%1000 != 0 {
+			return nil, errdefs.InvalidInputf("invalid extended resource %q: quantity %s is not a whole number", name, q.String())
+		}
+	}
+	return l, nil
+}
+
+// isExtendedResourceName reports whether name is the name of an extended
+// resource, as defined by the API server's validation.
+func isExtendedResourceName(name corev1.ResourceName) bool {
+	s := string(name)
+	if !strings.Contains(s, "/") || strings.HasPrefix(s, corev1.ResourceDefaultNamespacePrefix) ||
+		strings.HasPrefix(s, corev1.DefaultResourceRequestsPrefix) {
+		return false
+	}
+	// Quota requests are tracked as "requests.<name>", which must be valid
+	// as well.
+	return len(validation.IsQualifiedName(corev1.DefaultResourceRequestsPrefix+s)) == 0
+}
+
+func parseKeyValues(kind string, kvs []string, validate func(k, v string) []string) (map[string]string, error) {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, errdefs.InvalidInputf("invalid node %s %q: expected key=value", kind, kv)
+		}
+		k, v := kv[:i], kv[i+1:]
+		if msgs := validate(k, v); len(msgs) > 0 {
+			return nil, errdefs.InvalidInputf("invalid node %s %q: %s", kind, kv, strings.Join(msgs, ", "))
+		}
+		m[k] = v
+	}
+	return m, nil
+}
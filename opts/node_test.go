@@ -0,0 +1,74 @@
+package opts
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseTaints(t *testing.T) {
+	taints, err := ParseTaints([]string{"a=1:NoSchedule", "b:NoExecute", "a=2:NoSchedule", "a:PreferNoSchedule"})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(taints, []corev1.Taint{
+		{Key: "a", Value: "2", Effect: corev1.TaintEffectNoSchedule},
+		{Key: "b", Effect: corev1.TaintEffectNoExecute},
+		{Key: "a", Effect: corev1.TaintEffectPreferNoSchedule},
+	}))
+
+	for _, spec := range []string{"a=1", "a=1:Never", "in valid:NoSchedule", "a=in valid:NoSchedule"} {
+		_, err := ParseTaints([]string{spec})
+		assert.Check(t, err != nil, spec)
+	}
+}
+
+func TestParseNodeLabels(t *testing.T) {
+	labels, err := ParseNodeLabels([]string{"a=1", "example.com/b=", "a=2"})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(labels, map[string]string{"a": "2", "example.com/b": ""}))
+
+	for _, kv := range []string{"a", "in valid=1", "a=in valid"} {
+		_, err := ParseNodeLabels([]string{kv})
+		assert.Check(t, err != nil, kv)
+	}
+}
+
+func TestParseNodeAnnotations(t *testing.T) {
+	annotations, err := ParseNodeAnnotations([]string{"example.com/a=any value: at all"})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(annotations, map[string]string{"example.com/a": "any value: at all"}))
+
+	_, err = ParseNodeAnnotations([]string{"in valid=1"})
+	assert.Check(t, err != nil)
+}
+
+func TestParseResourceList(t *testing.T) {
+	l, err := ParseResourceList([]string{"cpu=1", "memory=1Gi", "cpu=500m", "example.com/gpu=2"})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(l, corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+		"example.com/gpu":     resource.MustParse("2"),
+	}))
+
+	for _, kv := range []string{"cpu", "cpu=lots", "cpu=-1", "in valid=1"} {
+		_, err := ParseResourceList([]string{kv})
+		assert.Check(t, err != nil, kv)
+	}
+}
+
+func TestParseExtendedResources(t *testing.T) {
+	l, err := ParseExtendedResources([]string{"example.com/license=10", "example.com/gpu=2"})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(l, corev1.ResourceList{
+		"example.com/license": resource.MustParse("10"),
+		"example.com/gpu":     resource.MustParse("2"),
+	}))
+
+	for _, kv := range []string{"cpu=1", "kubernetes.io/thing=1", "requests.example.com/a=1", "example.com/license=500m", "example.com/license"} {
+		_, err := ParseExtendedResources([]string{kv})
+		assert.Check(t, err != nil, kv)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"path"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+)
+
+// KeyPolicy decides which label or taint keys a node may be registered with.
+//
+// Allow and Deny hold patterns in the syntax of `path.Match`, so `*` does not
+// match the `/` between the prefix and the name of a key, e.g.
+// `*.kubernetes.io/*` matches `node-role.kubernetes.io/master` but not
+// `kubernetes.io/role`.
+//
+// A key is rejected if it matches a Deny pattern and no Allow pattern. Deny
+// `*` and `*/*` to only keep the allowed keys.
+type KeyPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Allowed reports whether key is allowed by the policy.
+func (p KeyPolicy) Allowed(key string) bool {
+	return matchAny(p.Allow, key) || !matchAny(p.Deny, key)
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeRestrictionLabels is the policy the NodeRestriction admission plugin
+// applies to the labels a kubelet registers its node with: labels under the
+// kubernetes.io and k8s.io prefixes are forbidden, except the well known ones
+// the kubelet sets itself and the ones under the kubelet.kubernetes.io and
+// node.kubernetes.io prefixes.
+var nodeRestrictionLabels = KeyPolicy{
+	Allow: []string{
+		"kubernetes.io/hostname",
+		"kubernetes.io/os",
+		"kubernetes.io/arch",
+		"beta.kubernetes.io/os",
+		"beta.kubernetes.io/arch",
+		"beta.kubernetes.io/instance-type",
+		"failure-domain.beta.kubernetes.io/region",
+		"failure-domain.beta.kubernetes.io/zone",
+		"topology.kubernetes.io/region",
+		"topology.kubernetes.io/zone",
+		"kubelet.kubernetes.io/*", "*.kubelet.kubernetes.io/*",
+		"node.kubernetes.io/*", "*.node.kubernetes.io/*",
+	},
+	Deny: []string{
+		"kubernetes.io/*", "*.kubernetes.io/*",
+		"k8s.io/*", "*.k8s.io/*",
+	},
+}
+
+// NodeLabelPolicy returns the policy for the labels the node is registered
+// with. With `NodeRestriction` set, the labels the NodeRestriction admission
+// plugin forbids are denied on top of `DeniedNodeLabels`.
+func (o *Opts) NodeLabelPolicy() KeyPolicy {
+	p := KeyPolicy{Allow: o.AllowedNodeLabels, Deny: o.DeniedNodeLabels}
+	if o.NodeRestriction {
+		p.Allow = append(append([]string(nil), p.Allow...), nodeRestrictionLabels.Allow...)
+		p.Deny = append(append([]string(nil), p.Deny...), nodeRestrictionLabels.Deny...)
+	}
+	return p
+}
+
+// NodeTaintPolicy returns the policy for the taints the node is registered
+// with.
+func (o *Opts) NodeTaintPolicy() KeyPolicy {
+	return KeyPolicy{Allow: o.AllowedNodeTaints, Deny: o.DeniedNodeTaints}
+}
+
+// validateKeyPatterns checks the patterns of a `KeyPolicy`.
+func validateKeyPatterns(what string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return errdefs.InvalidInputf("invalid %s pattern %q: %v", what, p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package opts
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	p := KeyPolicy{
+		Allow: []string{"node.example.com/*"},
+		Deny:  []string{"*.example.com/*", "example.com/*", "bare"},
+	}
+	for key, allowed := range map[string]bool{
+		"node.example.com/pool":  true,
+		"role.example.com/agent": false,
+		"example.com/role":       false,
+		"bare":                   false,
+		"other.io/role":          true,
+		"unprefixed":             true,
+	} {
+		assert.Check(t, is.Equal(p.Allowed(key), allowed), key)
+	}
+
+	assert.Check(t, KeyPolicy{}.Allowed("anything/goes"))
+	only := KeyPolicy{Allow: []string{"a"}, Deny: []string{"*", "*/*"}}
+	assert.Check(t, only.Allowed("a"))
+	assert.Check(t, !only.Allowed("b"))
+	assert.Check(t, !only.Allowed("x/b"))
+}
+
+func TestNodeLabelPolicy(t *testing.T) {
+	o := &Opts{DeniedNodeLabels: []string{"example.com/*"}}
+	assert.Check(t, o.NodeLabelPolicy().Allowed("node-role.kubernetes.io/master"))
+	assert.Check(t, !o.NodeLabelPolicy().Allowed("example.com/a"))
+
+	o.NodeRestriction = true
+	for key, allowed := range map[string]bool{
+		"node-role.kubernetes.io/master":     false,
+		"kubernetes.io/role":                 false,
+		"node-restriction.kubernetes.io/foo": false,
+		"example.k8s.io/foo":                 false,
+		"example.com/a":                      false,
+		"kubernetes.io/hostname":             true,
+		"topology.kubernetes.io/zone":        true,
+		"node.kubernetes.io/instance-type":   true,
+		"foo.kubelet.kubernetes.io/bar":      true,
+		"type":                               true,
+	} {
+		assert.Check(t, is.Equal(o.NodeLabelPolicy().Allowed(key), allowed), key)
+	}
+}
+
+func TestValidateKeyPatterns(t *testing.T) {
+	o := New()
+	o.Provider = "mock"
+	o.DeniedNodeTaints = []string{"[a"}
+	assert.Check(t, is.ErrorContains(o.Validate(nil), `invalid denied node taint pattern "[a"`))
+}
@@ -0,0 +1,143 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NodeSpec describes one of the nodes run by a single virtual-kubelet
+// process, see `Opts.Nodes`.
+type NodeSpec struct {
+	// Name is the name of the node.
+	Name string `json:"name"`
+	// Provider and ProviderConfigPath default to the top level options.
+	Provider           string `json:"provider,omitempty"`
+	ProviderConfigPath string `json:"providerConfigPath,omitempty" sensitive:"true"`
+	// ListenPort is the port the kubelet API server of the node listens
+	// on. Every node needs its own port, by default it is the top level
+	// `ListenPort` plus the index of the node.
+	ListenPort int32 `json:"listenPort,omitempty"`
+	// NodeLabels and RegisterWithTaints are added to the top level ones,
+	// in the same format.
+	NodeLabels         []string `json:"nodeLabels,omitempty"`
+	RegisterWithTaints []string `json:"registerWithTaints,omitempty"`
+}
+
+// NodeSpecs returns the nodes to run, with the defaults from the top level
+// options applied.
+// Without `Nodes`, this is the single node described by the top level
+// options.
+func (o *Opts) NodeSpecs() []NodeSpec {
+	if len(o.Nodes) == 0 {
+		return []NodeSpec{{
+			Name:               o.NodeName,
+			Provider:           o.Provider,
+			ProviderConfigPath: o.ProviderConfigPath,
+			ListenPort:         o.ListenPort,
+		}}
+	}
+
+	specs := make([]NodeSpec, 0, len(o.Nodes))
+	for i, spec := range o.Nodes {
+		if spec.Provider == "" {
+			spec.Provider = o.Provider
+		}
+		if spec.ProviderConfigPath == "" {
+			spec.ProviderConfigPath = o.ProviderConfigPath
+		}
+		if spec.ListenPort == 0 {
+			spec.ListenPort = o.ListenPort + int32(i)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// ForNode returns the options to run the node described by spec, as
+// returned by `NodeSpecs`: a copy of o with the fields of spec applied.
+func (o *Opts) ForNode(spec NodeSpec) *Opts {
+	n := *o
+	n.Nodes = nil
+	n.NodeName = spec.Name
+	n.Provider = spec.Provider
+	n.ProviderConfigPath = spec.ProviderConfigPath
+	n.ListenPort = spec.ListenPort
+	n.NodeLabels = append(append([]string(nil), o.NodeLabels...), spec.NodeLabels...)
+	n.RegisterWithTaints = append(append([]string(nil), o.RegisterWithTaints...), spec.RegisterWithTaints...)
+	return &n
+}
+
+// validateNodes checks the nodes set in `Nodes`.
+func (o *Opts) validateNodes(s *provider.Store) []error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, errdefs.InvalidInputf(format, args...))
+	}
+
+	if o.MetricsAddr != "" {
+		invalid("metrics address cannot be used with more than one node")
+	}
+	for _, a := range o.ListenAddresses {
+		if _, _, err := net.SplitHostPort(a); err == nil {
+			invalid("listen address %q cannot have a port with more than one node, set the port of each node instead", a)
+		}
+	}
+
+	names := make(map[string]bool, len(o.Nodes))
+	ports := make(map[int32]string, len(o.Nodes))
+	for _, spec := range o.NodeSpecs() {
+		if msgs := validation.IsDNS1123Subdomain(spec.Name); len(msgs) > 0 {
+			invalid("invalid node name %q: %s", spec.Name, strings.Join(msgs, ", "))
+		}
+		if names[spec.Name] {
+			invalid("node %q is listed more than once", spec.Name)
+		}
+		names[spec.Name] = true
+
+		switch {
+		case spec.Provider == "":
+			invalid("node %s: a provider must be set", spec.Name)
+		case s != nil && !s.Exists(spec.Provider):
+			invalid("node %s: provider %q not found", spec.Name, spec.Provider)
+		}
+
+		if msgs := validation.IsValidPortNum(int(spec.ListenPort)); len(msgs) > 0 {
+			invalid("node %s: invalid listen port %d: %s", spec.Name, spec.ListenPort, strings.Join(msgs, ", "))
+		} else if other, ok := ports[spec.ListenPort]; ok {
+			invalid("nodes %s and %s listen on the same port %d", other, spec.Name, spec.ListenPort)
+		}
+		ports[spec.ListenPort] = spec.Name
+
+		if spec.ProviderConfigPath != "" {
+			if _, err := os.Stat(spec.ProviderConfigPath); err != nil {
+				invalid("node %s: provider config: %v", spec.Name, err)
+			}
+		}
+		if _, err := ParseTaints(spec.RegisterWithTaints); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := ParseNodeLabels(spec.NodeLabels); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,83 @@
+package opts
+
+import (
+	"testing"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+func TestNodeSpecs(t *testing.T) {
+	o := New()
+	o.Provider = "mock"
+	o.ProviderConfigPath = "/etc/mock.json"
+	assert.Check(t, is.DeepEqual(o.NodeSpecs(), []NodeSpec{
+		{Name: DefaultNodeName, Provider: "mock", ProviderConfigPath: "/etc/mock.json", ListenPort: DefaultListenPort},
+	}))
+
+	o.Nodes = []NodeSpec{
+		{Name: "a"},
+		{Name: "b", Provider: "other", ProviderConfigPath: "/etc/other.json", ListenPort: 20000},
+		{Name: "c"},
+	}
+	assert.Check(t, is.DeepEqual(o.NodeSpecs(), []NodeSpec{
+		{Name: "a", Provider: "mock", ProviderConfigPath: "/etc/mock.json", ListenPort: DefaultListenPort},
+		{Name: "b", Provider: "other", ProviderConfigPath: "/etc/other.json", ListenPort: 20000},
+		{Name: "c", Provider: "mock", ProviderConfigPath: "/etc/mock.json", ListenPort: DefaultListenPort + 2},
+	}))
+}
+
+func TestForNode(t *testing.T) {
+	o := New()
+	o.Provider = "mock"
+	o.NodeLabels = []string{"shared=label"}
+	o.RegisterWithTaints = []string{"shared:NoSchedule"}
+	o.Nodes = []NodeSpec{{Name: "a", NodeLabels: []string{"region=a"}, RegisterWithTaints: []string{"pool=a:NoSchedule"}}}
+
+	n := o.ForNode(o.NodeSpecs()[0])
+	assert.Check(t, is.Equal(n.NodeName, "a"))
+	assert.Check(t, is.Equal(n.Provider, "mock"))
+	assert.Check(t, is.Len(n.Nodes, 0))
+	assert.Check(t, is.DeepEqual(n.NodeLabels, []string{"shared=label", "region=a"}))
+	assert.Check(t, is.DeepEqual(n.RegisterWithTaints, []string{"shared:NoSchedule", "pool=a:NoSchedule"}))
+	// The top level options are left untouched
+	assert.Check(t, is.DeepEqual(o.NodeLabels, []string{"shared=label"}))
+}
+
+func TestValidateNodes(t *testing.T) {
+	s := provider.NewStore()
+	s.Register("mock", nil)
+
+	o := New()
+	o.Provider = "mock"
+	o.Nodes = []NodeSpec{{Name: "a"}, {Name: "b"}}
+	assert.NilError(t, o.Validate(s))
+
+	// The top level provider is not needed when every node sets one
+	o.Provider = ""
+	o.Nodes = []NodeSpec{{Name: "a", Provider: "mock"}}
+	assert.NilError(t, o.Validate(s))
+
+	o.Provider = "mock"
+	o.MetricsAddr = ":10255"
+	o.ListenAddresses = []string{"127.0.0.1:10250"}
+	o.Nodes = []NodeSpec{
+		{Name: "a"},
+		{Name: "a", ListenPort: DefaultListenPort},
+		{Name: "Invalid_Name", Provider: "unknown"},
+		{Name: "d", NodeLabels: []string{"nope"}},
+	}
+	err := o.Validate(s)
+	agg, ok := err.(utilerrors.Aggregate)
+	assert.Assert(t, ok, "expected an aggregate error, got %T", err)
+	assert.Check(t, is.Len(agg.Errors(), 7), err.Error())
+	assert.Check(t, is.ErrorContains(err, "metrics address cannot be used with more than one node"))
+	assert.Check(t, is.ErrorContains(err, `listen address "127.0.0.1:10250" cannot have a port`))
+	assert.Check(t, is.ErrorContains(err, `node "a" is listed more than once`))
+	assert.Check(t, is.ErrorContains(err, "nodes a and a listen on the same port 10250"))
+	assert.Check(t, is.ErrorContains(err, `invalid node name "Invalid_Name"`))
+	assert.Check(t, is.ErrorContains(err, `node Invalid_Name: provider "unknown" not found`))
+	assert.Check(t, is.ErrorContains(err, `invalid node label "nope"`))
+}
@@ -17,12 +17,14 @@ package opts
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"time"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -32,7 +34,7 @@ const (
 	DefaultOperatingSystem      = "Linux"
 	DefaultInformerResyncPeriod = 1 * time.Minute
 	DefaultMetricsAddr          = ""
-	DefaultListenPort           = 10250 // TODO(cpuguy83)(VK1.0): Change this to an addr instead of just a port.. we should not be listening on all interfaces.
+	DefaultListenPort           = 10250
 	DefaultPodSyncWorkers       = 10
 	DefaultKubeNamespace        = corev1.NamespaceAll
 	DefaultKubeClusterDomain    = "cluster.local"
@@ -41,6 +43,43 @@ const (
 	DefaultTaintKey              = "virtual-kubelet.io/provider"
 	DefaultStreamIdleTimeout     = 4 * time.Hour
 	DefaultStreamCreationTimeout = 30 * time.Second
+
+	DefaultTLSMode       = TLSModeDisabled
+	DefaultTLSMinVersion = "VersionTLS12"
+
+	DefaultOnShutdown      = ShutdownLeave
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// The leader election defaults are the same as the kube-controller-manager's.
+	DefaultLeaderElectLeaseDuration     = 15 * time.Second
+	DefaultLeaderElectRenewDeadline     = 10 * time.Second
+	DefaultLeaderElectRetryPeriod       = 2 * time.Second
+	DefaultLeaderElectResourceNamespace = metav1.NamespaceSystem
+)
+
+// What to do with the node when virtual-kubelet shuts down, see `Opts.OnShutdown`.
+const (
+	// ShutdownLeave leaves the node as is, it goes NotReady once its
+	// heartbeats stop.
+	ShutdownLeave = "leave"
+	// ShutdownDelete deletes the node and its lease.
+	ShutdownDelete = "delete"
+	// ShutdownCordon marks the node unschedulable and taints it with
+	// NoExecute, so its pods are evicted.
+	ShutdownCordon = "cordon"
+)
+
+// How to serve the kubelet API without a serving certificate, see
+// `Opts.TLSMode`.
+const (
+	// TLSModeRequire fails startup.
+	TLSModeRequire = "require"
+	// TLSModeSelfSigned serves with a self-signed certificate generated at
+	// startup for the node addresses.
+	TLSModeSelfSigned = "self-signed"
+	// TLSModeDisabled does not serve the kubelet API, so logs, exec and
+	// metrics are not available.
+	TLSModeDisabled = "disabled"
 )
 
 // Opts stores all the options for configuring the root virtual-kubelet command.
@@ -49,21 +88,47 @@ const (
 // You can set the default options by creating a new `Opts` struct and passing
 // it into `SetDefaultOpts`
 type Opts struct {
+	// Path to a config file to load options from.
+	// See `Config` for the format of the file.
+	ConfigPath string
+	// Path to a kubelet.config.k8s.io/v1beta1 KubeletConfiguration file to
+	// load options from, see `LoadKubeletConfig`.
+	// Options in the file at ConfigPath take precedence over this one.
+	KubeletConfigPath string
+
 	// Path to the kubeconfig to use to connect to the Kubernetes API server.
 	KubeConfigPath string
 	// Namespace to watch for pods and other resources
 	KubeNamespace string
+	// Namespaces to watch for pods and other resources, all namespaces
+	// when empty. Use either this or KubeNamespace, not both.
+	Namespaces []string
+	// ExcludeNamespaces are namespaces not to watch.
+	// See `WatchedNamespaces` for how this combines with Namespaces.
+	ExcludeNamespaces []string
 	// Domain suffix to append to search domains for the pods created by virtual-kubelet
 	KubeClusterDomain string
 
 	// Sets the port to listen for requests from the Kubernetes API server
 	ListenPort int32
+	// ListenAddresses restricts the addresses to listen on, by default all
+	// interfaces are used. See `ListenAddrs` for the format.
+	ListenAddresses []string
 
 	// Node name to use when creating a node in Kubernetes
 	NodeName string
+	// Nodes are the nodes to run in this process, when more than one is
+	// needed. The top level options apply to all of them, except the
+	// fields set in each `NodeSpec`, see `ForNode`.
+	// When empty, the single node named NodeName is run.
+	Nodes []NodeSpec
 
 	// Operating system to run pods for
 	OperatingSystem string
+	// NodeArch is the CPU architecture the node reports, it also sets the
+	// arch labels. When empty the provider's value is used, or
+	// `runtime.GOARCH` if it sets none.
+	NodeArch string
 
 	Provider           string
 	ProviderConfigPath string
@@ -73,9 +138,77 @@ type Opts struct {
 	TaintValue   string
 	DisableTaint bool
 
+	// RegisterWithTaints are extra taints to register the node with, in the
+	// form `key[=value]:effect`.
+	// Like with the kubelet, they are only applied when the node is
+	// registered and changing them requires a restart.
+	RegisterWithTaints []string
+	// NodeLabels are extra labels to register the node with, in the form `key=value`.
+	// Like RegisterWithTaints, changing them requires a restart.
+	NodeLabels []string
+	// NodeAnnotations are extra annotations to register the node with, in the form `key=value`.
+	NodeAnnotations []string
+
+	// AllowedNodeLabels and DeniedNodeLabels are patterns of the label keys
+	// the node may be registered with, see `KeyPolicy`.
+	AllowedNodeLabels []string
+	DeniedNodeLabels  []string
+	// AllowedNodeTaints and DeniedNodeTaints are patterns of the taint keys
+	// the node may be registered with, see `KeyPolicy`.
+	AllowedNodeTaints []string
+	DeniedNodeTaints  []string
+	// NodeRestriction denies the labels the NodeRestriction admission plugin
+	// forbids a kubelet to set, see `NodeLabelPolicy`.
+	NodeRestriction bool
+	// StrictNodePolicy fails startup when the node has labels or taints
+	// rejected by the policies, rather than registering it without them.
+	StrictNodePolicy bool
+
+	// SystemReserved are resources, in the form `name=quantity`, subtracted
+	// from the allocatable resources the provider reports, like the
+	// kubelet's `--system-reserved`.
+	SystemReserved []string
+	// ExtendedResources are extended resources, in the form `name=quantity`,
+	// the node is registered with in addition to the resources the provider
+	// reports. Pods requesting more of them than the node has free are
+	// rejected.
+	ExtendedResources []string
+
 	MetricsAddr string
+	// HealthAddr is the address of the unauthenticated listener serving
+	// the `/healthz` and `/readyz` checks of the process, it is disabled if
+	// empty.
+	HealthAddr string
+
+	// TLSCertFile and TLSPrivateKeyFile are the serving certificate and key
+	// of the kubelet API server.
+	// The files are checked for changes every 10 seconds, so a rotated
+	// certificate is served up to 10 seconds after it is written.
+	TLSCertFile       string
+	TLSPrivateKeyFile string
+	// ServerTLSBootstrap gets the serving certificate from the certificates
+	// API, and renews it before it expires, like the kubelet's
+	// `--rotate-server-certificates`. TLSCertFile, if set, is served until
+	// the first certificate is issued.
+	ServerTLSBootstrap bool
+	// TLSMode is what to do when the kubelet API cannot be served because
+	// there is no serving certificate or client CA, one of TLSModeRequire,
+	// TLSModeSelfSigned or TLSModeDisabled.
+	TLSMode string
+	// TLSMinVersion is the minimum TLS version of the TLS listeners, e.g.
+	// VersionTLS13, see `ParseTLSVersion`.
+	TLSMinVersion string
+	// TLSCipherSuites are the cipher suites the TLS listeners allow, by
+	// their IANA or Go names, see `ParseTLSCipherSuites`. The default is a
+	// list without known weak cipher suites.
+	TLSCipherSuites []string
+
+	// MasterURI overrides the address of the Kubernetes API server from the
+	// kube config.
+	MasterURI string
 
 	// Only trust clients with tls certs signed by the provided CA
+	// The file is checked for changes every 10 seconds, like TLSCertFile.
 	ClientCACert string
 	// Do not require client tls verification
 	AllowUnauthenticatedClients bool
@@ -86,9 +219,34 @@ type Opts struct {
 
 	// Use node leases when supported by Kubernetes (instead of node status updates)
 	EnableNodeLease bool
+	// ForceTakeover starts even if another instance holds the node lease.
+	// The instance losing the lease stops running the node.
+	// An instance restarted on the same host takes its lease back without it
+	// once the lease is no longer renewed.
+	ForceTakeover bool
+
+	// LeaderElect runs the pod and node controllers only in the instance
+	// holding the leader election lease, so several instances can run the
+	// same node with one taking over when the leader goes away.
+	LeaderElect bool
+	// LeaderElectLeaseDuration, LeaderElectRenewDeadline and
+	// LeaderElectRetryPeriod tune the leader election, see
+	// `k8s.io/client-go/tools/leaderelection.LeaderElectionConfig`.
+	LeaderElectLeaseDuration time.Duration
+	LeaderElectRenewDeadline time.Duration
+	LeaderElectRetryPeriod   time.Duration
+	// LeaderElectResourceNamespace and LeaderElectResourceName locate the
+	// leader election lease, see `LeaderElectionLease`.
+	LeaderElectResourceNamespace string
+	LeaderElectResourceName      string
 
 	// Startup Timeout is how long to wait for the kubelet to start
 	StartupTimeout time.Duration
+	// OnShutdown is what to do with the node on shutdown, one of
+	// ShutdownLeave, ShutdownDelete or ShutdownCordon.
+	OnShutdown string
+	// ShutdownTimeout bounds how long OnShutdown may take.
+	ShutdownTimeout time.Duration
 	// StreamIdleTimeout is the maximum time a streaming connection
 	// can be idle before the connection is automatically closed.
 	StreamIdleTimeout time.Duration
@@ -100,6 +258,11 @@ type Opts struct {
 	// KubeAPIBurst is the burst to allow while talking with kubernetes apiserver
 	KubeAPIBurst int32
 
+	// Every node gets its own copy of the rate limiters of its pod controller
+	// queues when they were created by `ParseRateLimiter`, any other rate
+	// limiter is shared by all the nodes.
+	// The limits of the Kubernetes client above apply to the whole process.
+
 	// SyncPodsFromKubernetesRateLimiter defines the rate limit for the SyncPodsFromKubernetes queue
 	SyncPodsFromKubernetesRateLimiter workqueue.RateLimiter
 	// DeletePodsFromKubernetesRateLimiter defines the rate limit for the DeletePodsFromKubernetesRateLimiter queue
@@ -109,6 +272,11 @@ type Opts struct {
 
 	Version string
 
+	// LogLevel is the log level to use.
+	// The root command sets it on its logger when it is a logrus logger, see
+	// github.com/virtual-kubelet/virtual-kubelet/log/logrus.
+	LogLevel string
+
 	// authentication specifies how requests to the virtual-kubelet's server are authenticated
 	Authentication Authentication
 	// authorization specifies how requests to the virtual-kubelet's server are authorized
@@ -117,6 +285,9 @@ type Opts struct {
 
 // FromEnv sets default options for unset values on the passed in option struct.
 // Fields tht are already set will not be modified.
+//
+// Only the legacy environment variables are read here, the root command
+// applies all environment variables (including these) itself.
 func FromEnv() (*Opts, error) {
 	o := &Opts{}
 	setDefaults(o)
@@ -166,9 +337,52 @@ func setDefaults(o *Opts) {
 	o.StreamIdleTimeout = DefaultStreamIdleTimeout
 	o.StreamCreationTimeout = DefaultStreamCreationTimeout
 	o.EnableNodeLease = true
-	o.SyncPodsFromKubernetesRateLimiter = workqueue.DefaultControllerRateLimiter()
-	o.DeletePodsFromKubernetesRateLimiter = workqueue.DefaultControllerRateLimiter()
-	o.SyncPodStatusFromProviderRateLimiter = workqueue.DefaultControllerRateLimiter()
+	o.TLSMode = DefaultTLSMode
+	o.TLSMinVersion = DefaultTLSMinVersion
+	o.OnShutdown = DefaultOnShutdown
+	o.LeaderElectLeaseDuration = DefaultLeaderElectLeaseDuration
+	o.LeaderElectRenewDeadline = DefaultLeaderElectRenewDeadline
+	o.LeaderElectRetryPeriod = DefaultLeaderElectRetryPeriod
+	o.LeaderElectResourceNamespace = DefaultLeaderElectResourceNamespace
+	o.ShutdownTimeout = DefaultShutdownTimeout
+	o.SyncPodsFromKubernetesRateLimiter = mustParseRateLimiter(DefaultRateLimiterSpec)
+	o.DeletePodsFromKubernetesRateLimiter = mustParseRateLimiter(DefaultRateLimiterSpec)
+	o.SyncPodStatusFromProviderRateLimiter = mustParseRateLimiter(DefaultRateLimiterSpec)
+}
+
+// Diff returns the names of the fields which are different between a and b.
+// Fields of nested structs are named using their path, e.g.
+// "Authentication.Webhook.CacheTTL".
+//
+// Rate limiters created by `ParseRateLimiter` are compared by their spec.
+func Diff(a, b *Opts) []string {
+	return diff("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+}
+
+func diff(prefix string, a, b reflect.Value) []string {
+	var changed []string
+
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + f.Name
+		if f.Type.Kind() == reflect.Struct && f.Type != metav1DurationType {
+			changed = append(changed, diff(name+".", a.Field(i), b.Field(i))...)
+			continue
+		}
+		if f.Type == rateLimiterType {
+			as, _ := a.Field(i).Interface().(workqueue.RateLimiter)
+			bs, _ := b.Field(i).Interface().(workqueue.RateLimiter)
+			if spec := RateLimiterSpec(as); spec != "" && spec == RateLimiterSpec(bs) {
+				continue
+			}
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+
+	return changed
 }
 
 func getEnv(key, defaultValue string) string {
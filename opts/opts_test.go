@@ -0,0 +1,20 @@
+package opts
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestDiff(t *testing.T) {
+	a := New()
+	b := New()
+	assert.Check(t, is.Len(Diff(a, b), 0))
+
+	b.NodeName = "other"
+	b.Authorization.Webhook.CacheAuthorizedTTL.Duration = time.Minute
+	b.SyncPodsFromKubernetesRateLimiter = mustParseRateLimiter("bucket:1qps/1")
+	assert.Check(t, is.DeepEqual(Diff(a, b), []string{"NodeName", "SyncPodsFromKubernetesRateLimiter", "Authorization.Webhook.CacheAuthorizedTTL"}))
+}
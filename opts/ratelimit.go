@@ -0,0 +1,153 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultRateLimiterSpec is the spec of the rate limiter used for all the
+// pod controller queues by default.
+// It is the same as `workqueue.DefaultControllerRateLimiter()`.
+const DefaultRateLimiterSpec = "exponential:5ms-1000s,bucket:10qps/100"
+
+// parsedRateLimiter is a rate limiter created from a spec.
+// It keeps the spec around so it can be printed and compared.
+type parsedRateLimiter struct {
+	workqueue.RateLimiter
+	spec string
+}
+
+// ParseRateLimiter creates a workqueue rate limiter from a spec.
+//
+// A spec is a comma separated list of rate limiters, if more than one is
+// given the longest delay of all of them is used. The supported rate
+// limiters are:
+//
+//	exponential:<base>-<max>      per item exponential back-off, starting at
+//	                              base and capped at max, e.g. exponential:5ms-1000s
+//	bucket:<qps>qps/<burst>       overall token bucket, e.g. bucket:10qps/100
+//	fastslow:<fast>-<slow>/<n>    per item fast delay for the first n retries,
+//	                              then the slow delay, e.g. fastslow:5ms-10s/3
+//
+// Durations use the syntax of `time.ParseDuration`.
+func ParseRateLimiter(spec string) (workqueue.RateLimiter, error) {
+	var limiters []workqueue.RateLimiter
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		l, err := parseRateLimiterPart(part)
+		if err != nil {
+			return nil, errdefs.AsInvalidInput(errors.Wrapf(err, "invalid rate limiter %q", part))
+		}
+		limiters = append(limiters, l)
+	}
+
+	l := limiters[0]
+	if len(limiters) > 1 {
+		l = workqueue.NewMaxOfRateLimiter(limiters...)
+	}
+	return &parsedRateLimiter{RateLimiter: l, spec: spec}, nil
+}
+
+// RateLimiterSpec returns the spec the passed in rate limiter was created
+// from with `ParseRateLimiter`, or an empty string if it was not created
+// from a spec.
+func RateLimiterSpec(l workqueue.RateLimiter) string {
+	if p, ok := l.(*parsedRateLimiter); ok {
+		return p.spec
+	}
+	return ""
+}
+
+func mustParseRateLimiter(spec string) workqueue.RateLimiter {
+	l, err := ParseRateLimiter(spec)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func parseRateLimiterPart(s string) (workqueue.RateLimiter, error) {
+	kind, args := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, args = s[:i], s[i+1:]
+	}
+
+	switch kind {
+	case "exponential":
+		base, max, err := parseDurationRange(args)
+		if err != nil {
+			return nil, err
+		}
+		return workqueue.NewItemExponentialFailureRateLimiter(base, max), nil
+	case "bucket":
+		i := strings.Index(args, "qps/")
+		if i < 0 {
+			return nil, errors.New("expected <qps>qps/<burst>")
+		}
+		qps, err := strconv.ParseFloat(args[:i], 64)
+		if err != nil || qps <= 0 {
+			return nil, errors.Errorf("qps must be a number greater than 0, got %q", args[:i])
+		}
+		burst, err := strconv.Atoi(args[i+len("qps/"):])
+		if err != nil || burst <= 0 {
+			return nil, errors.Errorf("burst must be an integer greater than 0, got %q", args[i+len("qps/"):])
+		}
+		return &workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)}, nil
+	case "fastslow":
+		i := strings.LastIndex(args, "/")
+		if i < 0 {
+			return nil, errors.New("expected <fast>-<slow>/<attempts>")
+		}
+		fast, slow, err := parseDurationRange(args[:i])
+		if err != nil {
+			return nil, err
+		}
+		attempts, err := strconv.Atoi(args[i+1:])
+		if err != nil || attempts < 0 {
+			return nil, errors.Errorf("attempts must be a non-negative integer, got %q", args[i+1:])
+		}
+		return workqueue.NewItemFastSlowRateLimiter(fast, slow, attempts), nil
+	default:
+		return nil, errors.Errorf("unknown rate limiter type %q, must be one of exponential, bucket, fastslow", kind)
+	}
+}
+
+// parseDurationRange parses "<min>-<max>"
+func parseDurationRange(s string) (time.Duration, time.Duration, error) {
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return 0, 0, errors.New("expected <duration>-<duration>")
+	}
+	min, err := time.ParseDuration(s[:i])
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := time.ParseDuration(s[i+1:])
+	if err != nil {
+		return 0, 0, err
+	}
+	if min <= 0 || max < min {
+		return 0, 0, errors.Errorf("durations must be greater than 0 and in increasing order, got %s-%s", min, max)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,74 @@
+package opts
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseRateLimiter(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		l, err := ParseRateLimiter(DefaultRateLimiterSpec)
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(RateLimiterSpec(l), DefaultRateLimiterSpec))
+
+		assert.Check(t, is.Equal(l.When("a"), 5*time.Millisecond))
+		assert.Check(t, is.Equal(l.When("a"), 10*time.Millisecond))
+		assert.Check(t, is.Equal(l.NumRequeues("a"), 2))
+		l.Forget("a")
+		assert.Check(t, is.Equal(l.NumRequeues("a"), 0))
+	})
+
+	t.Run("exponential", func(t *testing.T) {
+		l, err := ParseRateLimiter("exponential:1s-3s")
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(l.When("a"), time.Second))
+		assert.Check(t, is.Equal(l.When("a"), 2*time.Second))
+		assert.Check(t, is.Equal(l.When("a"), 3*time.Second))
+	})
+
+	t.Run("fastslow", func(t *testing.T) {
+		l, err := ParseRateLimiter("fastslow:1ms-1s/2")
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(l.When("a"), time.Millisecond))
+		assert.Check(t, is.Equal(l.When("a"), time.Millisecond))
+		assert.Check(t, is.Equal(l.When("a"), time.Second))
+
+		// Without fast attempts every retry is slow
+		l, err = ParseRateLimiter("fastslow:1ms-1s/0")
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(l.When("a"), time.Second))
+
+		_, err = ParseRateLimiter("fastslow:1ms-1s/-1")
+		assert.Check(t, is.ErrorContains(err, "attempts must be a non-negative integer"))
+	})
+
+	t.Run("bucket", func(t *testing.T) {
+		l, err := ParseRateLimiter("bucket:0.5qps/1")
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal(l.When("a"), time.Duration(0)))
+		assert.Check(t, l.When("b") > time.Second)
+	})
+
+	for _, spec := range []string{
+		"",
+		"exponential",
+		"exponential:5ms",
+		"exponential:1s-5ms",
+		"exponential:0s-1s",
+		"bucket:10qps",
+		"bucket:0qps/10",
+		"bucket:10qps/x",
+		"fastslow:1ms-1s",
+		"fastslow:1ms-1s/-1",
+		"linear:1s-2s",
+		"exponential:5ms-1000s,",
+	} {
+		t.Run("invalid "+spec, func(t *testing.T) {
+			_, err := ParseRateLimiter(spec)
+			assert.Check(t, err != nil, spec)
+		})
+	}
+}
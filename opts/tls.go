@@ -0,0 +1,101 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"crypto/tls"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+)
+
+// tlsVersions are the supported `--tls-min-version` values, named like the
+// kubelet's. TLS 1.0 and 1.1 are not secure and cannot be used.
+var tlsVersions = map[string]uint16{
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+// cipherSuiteAliases are the Go names of cipher suites which differ from
+// their IANA names.
+var cipherSuiteAliases = map[string]string{
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":   "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305": "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// ParseTLSVersion parses a TLS version name, e.g. `VersionTLS13`.
+// An empty name returns 0, i.e. the default.
+func ParseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	if v, ok := tlsVersions[name]; ok {
+		return v, nil
+	}
+	switch name {
+	case "VersionTLS10", "VersionTLS11":
+		return 0, errdefs.InvalidInputf("tls version %s is insecure, must be VersionTLS12 or VersionTLS13", name)
+	}
+	return 0, errdefs.InvalidInputf("tls version %q is not supported, must be VersionTLS12 or VersionTLS13", name)
+}
+
+// ParseTLSCipherSuites parses cipher suite names, e.g.
+// `TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256`, into their IDs.
+// Both the IANA names and the names of the Go constants are accepted.
+//
+// Insecure cipher suites are rejected, as are TLS 1.3 ones which cannot be
+// configured.
+func ParseTLSCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	secure := make(map[string]*tls.CipherSuite)
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		iana := name
+		if alias, ok := cipherSuiteAliases[name]; ok {
+			iana = alias
+		}
+		if insecure[iana] {
+			return nil, errdefs.InvalidInputf("tls cipher suite %s is insecure", name)
+		}
+		s, ok := secure[iana]
+		if !ok {
+			return nil, errdefs.InvalidInputf("tls cipher suite %q is not supported", name)
+		}
+		if tls13Only(s) {
+			return nil, errdefs.InvalidInputf("tls cipher suite %s is a TLS 1.3 cipher suite, which cannot be configured", name)
+		}
+		ids = append(ids, s.ID)
+	}
+	return ids, nil
+}
+
+func tls13Only(s *tls.CipherSuite) bool {
+	for _, v := range s.SupportedVersions {
+		if v != tls.VersionTLS13 {
+			return false
+		}
+	}
+	return true
+}
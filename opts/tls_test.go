@@ -0,0 +1,45 @@
+package opts
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := ParseTLSVersion("VersionTLS13")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(v, uint16(tls.VersionTLS13)))
+
+	v, err = ParseTLSVersion("")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(v, uint16(0)))
+
+	_, err = ParseTLSVersion("VersionTLS11")
+	assert.Check(t, is.ErrorContains(err, "insecure"))
+	_, err = ParseTLSVersion("1.3")
+	assert.Check(t, is.ErrorContains(err, "not supported"))
+}
+
+func TestParseTLSCipherSuites(t *testing.T) {
+	ids, err := ParseTLSCipherSuites([]string{
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(ids, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	}))
+
+	_, err = ParseTLSCipherSuites([]string{"TLS_ECDHE_RSA_WITH_RC4_128_SHA"})
+	assert.Check(t, is.ErrorContains(err, "insecure"))
+	_, err = ParseTLSCipherSuites([]string{"TLS_AES_128_GCM_SHA256"})
+	assert.Check(t, is.ErrorContains(err, "TLS 1.3"))
+	_, err = ParseTLSCipherSuites([]string{"TLS_FOO"})
+	assert.Check(t, is.ErrorContains(err, "not supported"))
+}
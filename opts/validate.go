@@ -0,0 +1,252 @@
+// Copyright © 2021 The virtual-kubelet authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opts
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+)
+
+// Validate checks all the options and returns every problem found as a
+// single aggregated error, or nil if the options are valid.
+// The returned error implements `k8s.io/apimachinery/pkg/util/errors.Aggregate`.
+//
+// If s is not nil, the configured provider must be registered in s.
+//
+// Validate does not contact the Kubernetes API server.
+func (o *Opts) Validate(s *provider.Store) error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, errdefs.InvalidInputf(format, args...))
+	}
+
+	if len(o.Nodes) == 0 {
+		if msgs := validation.IsDNS1123Subdomain(o.NodeName); len(msgs) > 0 {
+			invalid("invalid node name %q: %s", o.NodeName, strings.Join(msgs, ", "))
+		}
+	}
+	if o.KubeNamespace != corev1.NamespaceAll {
+		if msgs := validation.IsDNS1123Label(o.KubeNamespace); len(msgs) > 0 {
+			invalid("invalid namespace %q: %s", o.KubeNamespace, strings.Join(msgs, ", "))
+		}
+		if len(o.Namespaces) > 0 {
+			invalid("namespace and namespaces are mutually exclusive")
+		}
+	}
+	for _, ns := range append(append([]string(nil), o.Namespaces...), o.ExcludeNamespaces...) {
+		if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+			invalid("invalid namespace %q: %s", ns, strings.Join(msgs, ", "))
+		}
+	}
+	if o.WatchedNamespaces().None {
+		invalid("all the namespaces to watch are excluded")
+	}
+
+	if ok := provider.ValidOperatingSystems[o.OperatingSystem]; !ok {
+		names := provider.ValidOperatingSystems.Names()
+		sort.Strings(names)
+		invalid("operating system %q is not supported, must be one of %s", o.OperatingSystem, names)
+	}
+
+	if o.NodeArch != "" {
+		if msgs := validation.IsValidLabelValue(o.NodeArch); len(msgs) > 0 {
+			invalid("invalid node architecture %q: %s", o.NodeArch, strings.Join(msgs, ", "))
+		}
+	}
+
+	if len(o.Nodes) == 0 {
+		switch {
+		case o.Provider == "":
+			invalid("a provider must be set")
+		case s != nil && !s.Exists(o.Provider):
+			invalid("provider %q not found", o.Provider)
+		}
+	} else {
+		errs = append(errs, o.validateNodes(s)...)
+	}
+
+	if msgs := validation.IsValidPortNum(int(o.ListenPort)); len(msgs) > 0 {
+		invalid("invalid listen port %d: %s", o.ListenPort, strings.Join(msgs, ", "))
+	}
+	if _, err := o.ListenAddrs(); err != nil {
+		errs = append(errs, err)
+	}
+	if o.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(o.MetricsAddr); err != nil {
+			invalid("invalid metrics address %q: %v", o.MetricsAddr, err)
+		}
+	}
+	if o.HealthAddr != "" {
+		if _, _, err := net.SplitHostPort(o.HealthAddr); err != nil {
+			invalid("invalid health address %q: %v", o.HealthAddr, err)
+		}
+	}
+
+	if !o.DisableTaint {
+		if msgs := validation.IsQualifiedName(o.TaintKey); len(msgs) > 0 {
+			invalid("invalid taint key %q: %s", o.TaintKey, strings.Join(msgs, ", "))
+		}
+		switch corev1.TaintEffect(o.TaintEffect) {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectNoExecute, corev1.TaintEffectPreferNoSchedule:
+		default:
+			invalid("taint effect %q is not supported", o.TaintEffect)
+		}
+	}
+
+	if _, err := ParseTaints(o.RegisterWithTaints); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseNodeLabels(o.NodeLabels); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseNodeAnnotations(o.NodeAnnotations); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseResourceList(o.SystemReserved); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseExtendedResources(o.ExtendedResources); err != nil {
+		errs = append(errs, err)
+	}
+	for _, p := range []struct {
+		what     string
+		patterns []string
+	}{
+		{"allowed node label", o.AllowedNodeLabels},
+		{"denied node label", o.DeniedNodeLabels},
+		{"allowed node taint", o.AllowedNodeTaints},
+		{"denied node taint", o.DeniedNodeTaints},
+	} {
+		if err := validateKeyPatterns(p.what, p.patterns); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if o.PodSyncWorkers <= 0 {
+		invalid("pod sync workers must be greater than 0")
+	}
+
+	switch o.OnShutdown {
+	case ShutdownLeave, ShutdownDelete, ShutdownCordon:
+	default:
+		invalid("on shutdown %q is not supported, must be one of %s, %s, %s", o.OnShutdown, ShutdownLeave, ShutdownDelete, ShutdownCordon)
+	}
+	if o.ShutdownTimeout <= 0 {
+		invalid("shutdown timeout must be greater than 0")
+	}
+
+	if o.LeaderElect {
+		errs = append(errs, o.validateLeaderElection()...)
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"full resync period", o.InformerResyncPeriod},
+		{"startup timeout", o.StartupTimeout},
+		{"stream idle timeout", o.StreamIdleTimeout},
+		{"stream creation timeout", o.StreamCreationTimeout},
+		{"authentication webhook cache ttl", o.Authentication.Webhook.CacheTTL.Duration},
+		{"authorization webhook cache authorized ttl", o.Authorization.Webhook.CacheAuthorizedTTL.Duration},
+		{"authorization webhook cache unauthorized ttl", o.Authorization.Webhook.CacheUnauthorizedTTL.Duration},
+	} {
+		if d.value < 0 {
+			invalid("%s must not be negative, got %s", d.name, d.value)
+		}
+	}
+
+	if o.KubeAPIQPS < 0 {
+		invalid("kube api qps must not be negative")
+	}
+	if o.KubeAPIBurst < 0 {
+		invalid("kube api burst must not be negative")
+	}
+	if o.KubeAPIQPS > 0 {
+		// This is the value the kubernetes client falls back to when unset.
+		burst := int32(rest.DefaultBurst)
+		if o.KubeAPIBurst > 0 {
+			burst = o.KubeAPIBurst
+		}
+		if burst < o.KubeAPIQPS {
+			invalid("kube api burst (%d) must not be lower than kube api qps (%d)", burst, o.KubeAPIQPS)
+		}
+	}
+
+	if o.ProviderConfigPath != "" {
+		if _, err := os.Stat(o.ProviderConfigPath); err != nil {
+			invalid("provider config: %v", err)
+		}
+	}
+	if o.ClientCACert != "" {
+		if _, err := os.Stat(o.ClientCACert); err != nil {
+			invalid("client CA cert: %v", err)
+		}
+	}
+	if (o.TLSCertFile == "") != (o.TLSPrivateKeyFile == "") {
+		invalid("tls cert file and tls private key file must be set together")
+	}
+	switch o.TLSMode {
+	case TLSModeRequire, TLSModeSelfSigned, TLSModeDisabled:
+	default:
+		invalid("tls mode %q is not supported, must be one of %s, %s, %s", o.TLSMode, TLSModeRequire, TLSModeSelfSigned, TLSModeDisabled)
+	}
+	minVersion, err := ParseTLSVersion(o.TLSMinVersion)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ParseTLSCipherSuites(o.TLSCipherSuites); err != nil {
+		errs = append(errs, err)
+	}
+	if minVersion == tls.VersionTLS13 && len(o.TLSCipherSuites) > 0 {
+		invalid("tls cipher suites cannot be set with tls min version %s, TLS 1.3 cipher suites are not configurable", o.TLSMinVersion)
+	}
+	for _, f := range []struct {
+		name string
+		path string
+	}{
+		{"tls cert file", o.TLSCertFile},
+		{"tls private key file", o.TLSPrivateKeyFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			invalid("%s: %v", f.name, err)
+		}
+	}
+	if o.MasterURI != "" {
+		if u, err := url.Parse(o.MasterURI); err != nil || u.Host == "" {
+			invalid("invalid master uri %q", o.MasterURI)
+		}
+	}
+	if o.Authentication.Webhook.Enabled && o.ClientCACert == "" {
+		invalid("webhook authentication requires a client CA cert")
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,128 @@
+package opts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/node-cli/provider"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+func TestValidate(t *testing.T) {
+	s := provider.NewStore()
+	s.Register("mock", nil)
+
+	o := New()
+	o.Provider = "mock"
+	assert.NilError(t, o.Validate(s))
+
+	o.Provider = "unknown"
+	o.ListenPort = 0
+	o.PodSyncWorkers = 0
+	o.TaintEffect = "Sometimes"
+	o.InformerResyncPeriod = -time.Second
+	o.KubeAPIQPS = 50
+	o.ClientCACert = "/some/nonexistent/path"
+	o.Authentication.Webhook.Enabled = true
+
+	err := o.Validate(s)
+	agg, ok := err.(utilerrors.Aggregate)
+	assert.Assert(t, ok, "expected an aggregate error, got %T", err)
+	assert.Check(t, is.Len(agg.Errors(), 7), err.Error())
+	assert.Check(t, is.ErrorContains(err, `provider "unknown" not found`))
+	assert.Check(t, is.ErrorContains(err, "invalid listen port 0"))
+	assert.Check(t, is.ErrorContains(err, "pod sync workers must be greater than 0"))
+	assert.Check(t, is.ErrorContains(err, `taint effect "Sometimes" is not supported`))
+	assert.Check(t, is.ErrorContains(err, "full resync period must not be negative"))
+	assert.Check(t, is.ErrorContains(err, "kube api burst (10) must not be lower than kube api qps (50)"))
+	assert.Check(t, is.ErrorContains(err, "client CA cert"))
+
+	o.ClientCACert = ""
+	assert.Check(t, is.ErrorContains(o.Validate(s), "webhook authentication requires a client CA cert"))
+
+	// Without a store the provider is not checked
+	err = o.Validate(nil)
+	assert.Assert(t, err != nil)
+	assert.Check(t, !strings.Contains(err.Error(), "unknown"), err.Error())
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	o := New()
+	o.Provider = "mock"
+	o.Namespaces = []string{"a", "B"}
+	o.ExcludeNamespaces = []string{"kube_system"}
+	o.KubeNamespace = "c"
+
+	err := o.Validate(nil)
+	assert.Check(t, is.ErrorContains(err, "namespace and namespaces are mutually exclusive"))
+	assert.Check(t, is.ErrorContains(err, `invalid namespace "B"`))
+	assert.Check(t, is.ErrorContains(err, `invalid namespace "kube_system"`))
+
+	o.KubeNamespace = ""
+	o.Namespaces = []string{"a"}
+	o.ExcludeNamespaces = []string{"a"}
+	assert.Check(t, is.ErrorContains(o.Validate(nil), "all the namespaces to watch are excluded"))
+
+	o.Namespaces = nil
+	o.KubeNamespace = "a"
+	assert.Check(t, is.ErrorContains(o.Validate(nil), "all the namespaces to watch are excluded"))
+}
+
+func TestValidateTLSPolicy(t *testing.T) {
+	o := New()
+	o.Provider = "mock"
+	o.TLSMinVersion = "VersionTLS13"
+	assert.NilError(t, o.Validate(nil))
+
+	o.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	assert.Check(t, is.ErrorContains(o.Validate(nil), "tls cipher suites cannot be set with tls min version VersionTLS13"))
+
+	o.TLSMinVersion = "VersionTLS10"
+	o.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	err := o.Validate(nil)
+	assert.Check(t, is.ErrorContains(err, "tls version VersionTLS10 is insecure"))
+	assert.Check(t, is.ErrorContains(err, "tls cipher suite TLS_RSA_WITH_RC4_128_SHA is insecure"))
+}
+
+func TestValidateServerTLSBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vk-validate")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	o := New()
+	o.Provider = "mock"
+	o.ServerTLSBootstrap = true
+	assert.NilError(t, o.Validate(nil))
+
+	// The certificate file is served until a certificate is issued
+	o.TLSCertFile = filepath.Join(dir, "cert.pem")
+	o.TLSPrivateKeyFile = filepath.Join(dir, "key.pem")
+	assert.NilError(t, ioutil.WriteFile(o.TLSCertFile, nil, 0600))
+	assert.NilError(t, ioutil.WriteFile(o.TLSPrivateKeyFile, nil, 0600))
+	assert.NilError(t, o.Validate(nil))
+}
+
+func TestValidateLeaderElection(t *testing.T) {
+	o := New()
+	o.Provider = "mock"
+	o.LeaderElect = true
+	assert.NilError(t, o.Validate(nil))
+
+	namespace, name := o.LeaderElectionLease()
+	assert.Check(t, is.Equal(namespace, "kube-system"))
+	assert.Check(t, is.Equal(name, "virtual-kubelet-virtual-kubelet"))
+
+	o.LeaderElectResourceName = "Not_Valid"
+	o.LeaderElectRenewDeadline = o.LeaderElectLeaseDuration
+	o.LeaderElectRetryPeriod = o.LeaderElectLeaseDuration
+	err := o.Validate(nil)
+	assert.Check(t, is.ErrorContains(err, `invalid leader election lease name "Not_Valid"`))
+	assert.Check(t, is.ErrorContains(err, "lease duration (15s) must be greater than the renew deadline (15s)"))
+	assert.Check(t, is.ErrorContains(err, "renew deadline (15s) must be greater than 18s"))
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ServingCertificateExpiring is the type of the condition of the built-in
+// check of the kubelet API serving certificate of every node. It is True once
+// less than a fifth of the validity of the certificate is left.
+// A check registered for this type replaces the built-in one.
+const ServingCertificateExpiring v1.NodeConditionType = "ServingCertificateExpiring"
+
+// DefaultNodeConditionCheckInterval is how often a node condition check runs
+// when it does not set an interval.
+const DefaultNodeConditionCheckInterval = 10 * time.Second
+
+// NodeConditionCheck is a health check which periodically sets one
+// condition of the node status, e.g. whether the backend API of the provider
+// is reachable.
+//
+// The condition set by the check replaces any condition of the same type set
+// by the provider.
+type NodeConditionCheck struct {
+	// Type is the type of the node condition owned by the check.
+	// Every check must own a different type.
+	Type v1.NodeConditionType
+	// Interval is how often the check runs, DefaultNodeConditionCheckInterval
+	// when zero.
+	Interval time.Duration
+	// Timeout bounds how long a single run of the check may take, it defaults
+	// to Interval. A check which times out sets the condition to Unknown.
+	Timeout time.Duration
+	// Check runs the check for the named node.
+	Check func(ctx context.Context, nodeName string) NodeConditionResult
+}
+
+// NodeConditionResult is the result of a `NodeConditionCheck`.
+type NodeConditionResult struct {
+	Status  v1.ConditionStatus
+	Reason  string
+	Message string
+}
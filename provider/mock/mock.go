@@ -368,7 +368,6 @@ func (p *ProviderV0) ConfigureNode(ctx context.Context, n *v1.Node) {
 		os = "Linux"
 	}
 	n.Status.NodeInfo.OperatingSystem = os
-	n.Status.NodeInfo.Architecture = "amd64"
 	n.ObjectMeta.Labels["alpha.service-controller.kubernetes.io/exclude-balancer"] = "true"
 }
 